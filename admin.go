@@ -0,0 +1,239 @@
+package nats_proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultRecentErrors specifies how many of the most recent errors the Gateway retains for the admin api
+	DefaultRecentErrors = 50
+)
+
+// Instrumented is implemented by stateful components, such as the Retrier, that provide a Filter and report their
+// state on the admin api
+type Instrumented interface {
+	Filter() Filter
+	Stats() interface{}
+}
+
+// ErrorEntry records a single failed request
+type ErrorEntry struct {
+	Time    time.Time `json:"time"`
+	Method  string    `json:"method"`
+	Path    string    `json:"path"`
+	Subject string    `json:"subject,omitempty"`
+	Error   string    `json:"error"`
+}
+
+// errorLog is a fixed size ring of the most recent errors
+type errorLog struct {
+	mu      sync.Mutex
+	entries []ErrorEntry
+	next    int
+	full    bool
+}
+
+func newErrorLog(size int) *errorLog {
+	return &errorLog{
+		entries: make([]ErrorEntry, size),
+	}
+}
+
+func (e *errorLog) add(entry ErrorEntry) {
+	if len(e.entries) == 0 {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.entries[e.next] = entry
+	e.next = (e.next + 1) % len(e.entries)
+	if e.next == 0 {
+		e.full = true
+	}
+}
+
+// recent returns the retained errors, most recent first
+func (e *errorLog) recent() []ErrorEntry {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	n := e.next
+	if e.full {
+		n = len(e.entries)
+	}
+
+	entries := make([]ErrorEntry, 0, n)
+	for i := 1; i <= n; i++ {
+		entries = append(entries, e.entries[(e.next-i+len(e.entries))%len(e.entries)])
+	}
+	return entries
+}
+
+// filterName derives a readable name for a Filter from the function that constructed it e.g. SetHeaders
+func filterName(f Filter) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+
+	name := fn.Name()
+	if index := strings.LastIndex(name, "/"); index >= 0 {
+		name = name[index+1:]
+	}
+
+	var segments []string
+	for i, segment := range strings.Split(name, ".") {
+		if i == 0 || strings.HasPrefix(segment, "func") {
+			continue // package name or anonymous func
+		}
+		segments = append(segments, segment)
+	}
+	if len(segments) == 0 {
+		return name
+	}
+	return strings.Join(segments, ".")
+}
+
+// AdminConfig describes the current configuration of the Gateway
+type AdminConfig struct {
+	Subject     string       `json:"subject"`
+	Headers     []string     `json:"headers"`
+	Cookies     []string     `json:"cookies"`
+	HeaderRules []HeaderRule `json:"header_rules"`
+	Timeout     string       `json:"timeout"`
+	Filters     []string     `json:"filters"`
+	LogLevel    string       `json:"log_level"`
+}
+
+// AdminStats describes the current state of the Gateway's nats connection
+type AdminStats struct {
+	Nats     *NatsStatus `json:"nats"`
+	InFlight int64       `json:"in_flight"`
+	InMsgs   uint64      `json:"in_msgs"`
+	OutMsgs  uint64      `json:"out_msgs"`
+	InBytes  uint64      `json:"in_bytes"`
+	OutBytes uint64      `json:"out_bytes"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Admin returns an http.Handler exposing the Gateway's configuration, nats stats and recent errors, and allowing the
+// log level and timeout to be changed at runtime.  The handler is intended to be served from a separate, private
+// listener.  If token is not empty, requests must provide it as a bearer token.
+//
+//	GET  /config                  current configuration
+//	GET  /stats                   nats connection stats
+//	GET  /errors                  most recent errors, newest first
+//	GET  /metrics                 stats of the components registered via WithInstrumented
+//	GET  /services                live services, if WithServicesPath was specified
+//	POST /log-level?level=debug   change the log level
+//	POST /timeout?timeout=5s      change the request timeout
+func (p *Gateway) Admin(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/config", p.adminConfig)
+	mux.HandleFunc("/stats", p.adminStats)
+	mux.HandleFunc("/errors", p.adminErrors)
+	mux.HandleFunc("/metrics", p.adminMetrics)
+	mux.HandleFunc("/log-level", p.adminLogLevel)
+	mux.HandleFunc("/timeout", p.adminTimeout)
+	if p.registry != nil {
+		mux.HandleFunc("/services", p.services)
+	}
+
+	if token == "" {
+		return mux
+	}
+
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, req)
+	})
+}
+
+func (p *Gateway) adminConfig(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, AdminConfig{
+		Subject:     p.subject,
+		Headers:     p.headers.patterns(false),
+		Cookies:     p.headers.patterns(true),
+		HeaderRules: p.headers,
+		Timeout:     p.Timeout().String(),
+		Filters:     p.filters,
+		LogLevel:    p.LogLevel().String(),
+	})
+}
+
+func (p *Gateway) adminStats(w http.ResponseWriter, _ *http.Request) {
+	stats := p.nc.Stats()
+	writeJSON(w, http.StatusOK, AdminStats{
+		Nats:     natsStatus(p.nc),
+		InFlight: atomic.LoadInt64(&p.inFlight),
+		InMsgs:   stats.InMsgs,
+		OutMsgs:  stats.OutMsgs,
+		InBytes:  stats.InBytes,
+		OutBytes: stats.OutBytes,
+	})
+}
+
+func (p *Gateway) adminErrors(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, p.errors.recent())
+}
+
+func (p *Gateway) adminMetrics(w http.ResponseWriter, _ *http.Request) {
+	metrics := map[string]interface{}{}
+	for name, i := range p.metrics {
+		metrics[name] = i.Stats()
+	}
+	writeJSON(w, http.StatusOK, metrics)
+}
+
+func (p *Gateway) adminLogLevel(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost && req.Method != http.MethodPut {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	level, err := ParseLevel(req.FormValue("level"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p.SetLogLevel(level)
+	p.adminConfig(w, req)
+}
+
+func (p *Gateway) adminTimeout(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost && req.Method != http.MethodPut {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	timeout, err := time.ParseDuration(req.FormValue("timeout"))
+	if err != nil || timeout < 0 {
+		http.Error(w, "timeout must be a non-negative duration e.g. 5s", http.StatusBadRequest)
+		return
+	}
+
+	p.SetTimeout(timeout)
+	p.adminConfig(w, req)
+}
@@ -0,0 +1,91 @@
+package nats_proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func passthrough() Filter {
+	return func(h Handler) Handler {
+		return h
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	gw, err := NewGateway(
+		WithHandler(func(ctx context.Context, subject string, message *Message) (*Message, error) {
+			return nil, errors.New("boom")
+		}),
+		WithFilters(passthrough()),
+		WithLogLevel(LevelOff),
+	)
+	assert.Nil(t, err)
+	defer gw.Close()
+
+	admin := gw.Admin("secret")
+
+	call := func(method, target string, v interface{}) int {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+		if v != nil {
+			assert.Nil(t, json.NewDecoder(w.Body).Decode(v))
+		}
+		return w.Code
+	}
+
+	t.Run("token", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, httptest.NewRequest("GET", "/config", nil))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("config", func(t *testing.T) {
+		config := AdminConfig{}
+		assert.Equal(t, http.StatusOK, call("GET", "/config", &config))
+		assert.Equal(t, DefaultSubject, config.Subject)
+		assert.Equal(t, []string{"passthrough"}, config.Filters)
+		assert.Contains(t, config.Headers, "Content-Type")
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, call("POST", "/timeout?timeout=3s", nil))
+		assert.Equal(t, time.Second*3, gw.Timeout())
+		assert.Equal(t, http.StatusBadRequest, call("POST", "/timeout?timeout=soon", nil))
+	})
+
+	t.Run("log-level", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, call("POST", "/log-level?level=debug", nil))
+		assert.Equal(t, LevelDebug, gw.LogLevel())
+		gw.SetLogLevel(LevelOff)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		gw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost/a/b", nil))
+
+		var entries []ErrorEntry
+		assert.Equal(t, http.StatusOK, call("GET", "/errors", &entries))
+		if assert.Len(t, entries, 1) {
+			assert.Equal(t, "api.a.b", entries[0].Subject)
+			assert.Equal(t, "boom", entries[0].Error)
+		}
+	})
+}
+
+func TestErrorLog(t *testing.T) {
+	e := newErrorLog(2)
+	e.add(ErrorEntry{Error: "a"})
+	e.add(ErrorEntry{Error: "b"})
+	e.add(ErrorEntry{Error: "c"})
+
+	recent := e.recent()
+	assert.Equal(t, []ErrorEntry{{Error: "c"}, {Error: "b"}}, recent)
+}
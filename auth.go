@@ -0,0 +1,418 @@
+package nats_proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrNoCredentials is returned by an Authenticator when the request carries no credentials it understands, allowing
+	// the next Authenticator to try
+	ErrNoCredentials = errors.New("nats_proxy: no credentials")
+
+	// ErrInvalidCredentials is returned by an Authenticator when the credentials presented are not valid
+	ErrInvalidCredentials = errors.New("nats_proxy: invalid credentials")
+)
+
+const (
+	// DefaultAPIKeyHeader specifies the header api keys are read from
+	DefaultAPIKeyHeader = "X-Api-Key"
+
+	// DefaultJWTLeeway specifies the clock skew tolerated when checking the exp and nbf claims of a JWT
+	DefaultJWTLeeway = time.Minute
+)
+
+// Authenticator verifies the credentials of a request and returns the Identity of the caller
+type Authenticator interface {
+	// Authenticate returns the Identity of the caller; ErrNoCredentials if the request has no credentials of this kind
+	Authenticate(ctx context.Context, req *http.Request) (*Identity, error)
+
+	// Challenge returns the WWW-Authenticate challenge for this kind of credentials e.g. Bearer
+	Challenge() string
+}
+
+// JWTOption configures the JWT Authenticator
+type JWTOption func(*jwtAuthenticator)
+
+// JWTIssuer requires the iss claim to equal one of the issuers provided
+func JWTIssuer(issuers ...string) JWTOption {
+	return func(j *jwtAuthenticator) {
+		j.issuers = append(j.issuers, issuers...)
+	}
+}
+
+// JWTAudience requires the aud claim to contain one of the audiences provided
+func JWTAudience(audiences ...string) JWTOption {
+	return func(j *jwtAuthenticator) {
+		j.audiences = append(j.audiences, audiences...)
+	}
+}
+
+// JWTLeeway specifies the clock skew tolerated when checking the exp and nbf claims; defaults to
+// ```nats_proxy.DefaultJWTLeeway```
+func JWTLeeway(d time.Duration) JWTOption {
+	return func(j *jwtAuthenticator) {
+		j.leeway = d
+	}
+}
+
+type jwtAuthenticator struct {
+	keys      KeySet
+	issuers   []string
+	audiences []string
+	leeway    time.Duration
+}
+
+// JWT returns an Authenticator for bearer tokens signed by a key in keys, such as NewJWKS.  RS, PS, ES, HS and EdDSA
+// algorithms are supported.  Every claim is passed to the service, non-string claims as json
+func JWT(keys KeySet, opts ...JWTOption) Authenticator {
+	j := &jwtAuthenticator{
+		keys:   keys,
+		leeway: DefaultJWTLeeway,
+	}
+
+	for _, opt := range opts {
+		opt(j)
+	}
+
+	return j
+}
+
+func (j *jwtAuthenticator) Challenge() string {
+	return "Bearer"
+}
+
+func (j *jwtAuthenticator) Authenticate(ctx context.Context, req *http.Request) (*Identity, error) {
+	authorization := req.Header.Get("Authorization")
+	if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "Bearer ") {
+		return nil, ErrNoCredentials
+	}
+
+	claims, all, err := verifyJWT(ctx, strings.TrimSpace(authorization[7:]), j.keys, time.Now(), j.leeway)
+	if err != nil {
+		log.Printf("Rejected JWT, %v\n", err)
+		return nil, ErrInvalidCredentials
+	}
+
+	if len(j.issuers) > 0 && !contains(j.issuers, claims.Issuer) {
+		log.Printf("Rejected JWT, unexpected issuer %v\n", claims.Issuer)
+		return nil, ErrInvalidCredentials
+	}
+
+	if len(j.audiences) > 0 {
+		matched := false
+		for _, aud := range j.audiences {
+			if contains(claims.Audience, aud) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			log.Printf("Rejected JWT, unexpected audience %v\n", claims.Audience)
+			return nil, ErrInvalidCredentials
+		}
+	}
+
+	identity := &Identity{
+		Subject: claims.Subject,
+		Method:  "jwt",
+		Claims:  map[string]string{},
+	}
+	for k, v := range all {
+		identity.Claims[k] = claimValue(v)
+	}
+	return identity, nil
+}
+
+// APIKeyStore looks up the Identity that owns an api key; a nil Identity and nil error indicate an unknown key
+type APIKeyStore interface {
+	Lookup(ctx context.Context, key string) (*Identity, error)
+}
+
+type apiKeys map[string]*Identity
+
+// NewMemoryAPIKeyStore returns an APIKeyStore holding the keys provided, mapped to their owners
+func NewMemoryAPIKeyStore(keys map[string]*Identity) APIKeyStore {
+	return apiKeys(keys)
+}
+
+func (a apiKeys) Lookup(_ context.Context, key string) (*Identity, error) {
+	for candidate, identity := range a {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(key)) == 1 {
+			return identity, nil
+		}
+	}
+	return nil, nil
+}
+
+// ReadAPIKeys reads api keys from a file with one key per line followed by the subject that owns it e.g.
+//
+//	6f1d0c2e9a subject-a
+//
+// Blank lines and lines starting with # are ignored
+func ReadAPIKeys(filename string) (APIKeyStore, error) {
+	keys := map[string]*Identity{}
+	err := readLines(filename, func(line string) {
+		fields := strings.Fields(line)
+		subject := ""
+		if len(fields) > 1 {
+			subject = fields[1]
+		}
+		keys[fields[0]] = &Identity{Subject: subject}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewMemoryAPIKeyStore(keys), nil
+}
+
+type apiKeyAuthenticator struct {
+	header string
+	store  APIKeyStore
+}
+
+// APIKey returns an Authenticator for api keys presented in the specified header, DefaultAPIKeyHeader if empty
+func APIKey(header string, store APIKeyStore) Authenticator {
+	if header == "" {
+		header = DefaultAPIKeyHeader
+	}
+
+	return &apiKeyAuthenticator{
+		header: header,
+		store:  store,
+	}
+}
+
+func (a *apiKeyAuthenticator) Challenge() string {
+	return ""
+}
+
+func (a *apiKeyAuthenticator) Authenticate(ctx context.Context, req *http.Request) (*Identity, error) {
+	key := req.Header.Get(a.header)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+
+	owner, err := a.store.Lookup(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if owner == nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Identity{
+		Subject: owner.Subject,
+		Method:  "apikey",
+		Claims:  owner.Claims,
+	}, nil
+}
+
+type basicAuthenticator struct {
+	realm string
+	users map[string]string
+}
+
+// Basic returns an Authenticator for HTTP Basic credentials checked against users, a map of username to password
+func Basic(realm string, users map[string]string) Authenticator {
+	return &basicAuthenticator{
+		realm: realm,
+		users: users,
+	}
+}
+
+// ReadBasicUsers reads users for Basic from a file with one username:password per line.  Blank lines and lines
+// starting with # are ignored
+func ReadBasicUsers(filename string) (map[string]string, error) {
+	users := map[string]string{}
+	err := readLines(filename, func(line string) {
+		if segments := strings.SplitN(line, ":", 2); len(segments) == 2 {
+			users[segments[0]] = segments[1]
+		}
+	})
+	return users, err
+}
+
+func (b *basicAuthenticator) Challenge() string {
+	return `Basic realm="` + strings.Replace(b.realm, `"`, "", -1) + `"`
+}
+
+func (b *basicAuthenticator) Authenticate(_ context.Context, req *http.Request) (*Identity, error) {
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	expected, found := b.users[username]
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(password)) != 1 || !found {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Identity{
+		Subject: username,
+		Method:  "basic",
+	}, nil
+}
+
+type anonymous struct{}
+
+// Anonymous returns an Authenticator that admits requests without credentials; place it last to make authentication
+// optional.  Requests that present invalid credentials are still rejected
+func Anonymous() Authenticator {
+	return anonymous{}
+}
+
+func (anonymous) Challenge() string {
+	return ""
+}
+
+func (anonymous) Authenticate(_ context.Context, _ *http.Request) (*Identity, error) {
+	return nil, nil
+}
+
+// AuthStats reports the Auth's activity
+type AuthStats struct {
+	Authenticated uint64 `json:"authenticated"`
+	Anonymous     uint64 `json:"anonymous"`
+	Rejected      uint64 `json:"rejected"`
+	Errors        uint64 `json:"errors"`
+}
+
+// Auth authenticates requests at the edge, rejecting those without valid credentials with 401 Unauthorized before
+// anything is published to nats.  The Identity of the caller is passed to the service in Message; a Router that
+// verifies signatures makes it available via RequestIdentity.  Authenticators are tried in order until one finds
+// credentials it understands
+type Auth struct {
+	authenticated  uint64 // accessed atomically
+	anonymous      uint64 // accessed atomically
+	rejected       uint64 // accessed atomically
+	errors         uint64 // accessed atomically
+	authenticators []Authenticator
+}
+
+// NewAuth returns a new Auth; register it with WithInstrumented to report its stats on the admin api
+func NewAuth(authenticators ...Authenticator) *Auth {
+	return &Auth{
+		authenticators: authenticators,
+	}
+}
+
+// Authenticate returns a Filter that requires valid credentials; shorthand for NewAuth(authenticators...).Filter()
+func Authenticate(authenticators ...Authenticator) Filter {
+	return NewAuth(authenticators...).Filter()
+}
+
+// Stats returns a snapshot of the Auth's activity
+func (a *Auth) Stats() interface{} {
+	return AuthStats{
+		Authenticated: atomic.LoadUint64(&a.authenticated),
+		Anonymous:     atomic.LoadUint64(&a.anonymous),
+		Rejected:      atomic.LoadUint64(&a.rejected),
+		Errors:        atomic.LoadUint64(&a.errors),
+	}
+}
+
+func (a *Auth) unauthorized() *Message {
+	atomic.AddUint64(&a.rejected, 1)
+
+	out := &Message{
+		Status: http.StatusUnauthorized,
+		Header: map[string]string{
+			"Content-Type": "text/plain; charset=utf-8",
+		},
+		Body: []byte(http.StatusText(http.StatusUnauthorized) + "\n"),
+	}
+
+	var challenges []string
+	for _, authenticator := range a.authenticators {
+		if challenge := authenticator.Challenge(); challenge != "" {
+			challenges = append(challenges, challenge)
+		}
+	}
+	if len(challenges) > 0 {
+		out.Header["Www-Authenticate"] = strings.Join(challenges, ", ")
+	}
+
+	return out
+}
+
+// Filter returns the Filter that authenticates requests
+func (a *Auth) Filter() Filter {
+	return func(h Handler) Handler {
+		return func(ctx context.Context, subject string, message *Message) (*Message, error) {
+			req, ok := HTTPRequest(ctx)
+			if !ok || message == nil {
+				return a.unauthorized(), nil
+			}
+
+			message.Identity = nil // only ever set by the gateway
+			for _, authenticator := range a.authenticators {
+				identity, err := authenticator.Authenticate(ctx, req)
+				switch {
+				case err == ErrNoCredentials:
+					continue
+				case err == ErrInvalidCredentials:
+					return a.unauthorized(), nil
+				case err != nil:
+					atomic.AddUint64(&a.errors, 1)
+					return nil, err
+				}
+
+				if identity == nil {
+					atomic.AddUint64(&a.anonymous, 1)
+				} else {
+					atomic.AddUint64(&a.authenticated, 1)
+					message.Identity = identity
+				}
+				return h(ctx, subject, message)
+			}
+
+			return a.unauthorized(), nil
+		}
+	}
+}
+
+type identityKey struct{}
+
+// RequestIdentity returns the Identity of the caller established by the Gateway; available to handlers wrapped by a
+// Router configured WithVerifyKeys, as only a signed message proves the Identity came from the Gateway
+func RequestIdentity(req *http.Request) (*Identity, bool) {
+	identity, ok := req.Context().Value(identityKey{}).(*Identity)
+	return identity, ok && identity != nil
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// readLines calls fn for each line of the file that is neither blank nor a comment
+func readLines(filename string, fn func(line string)) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fn(line)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,315 @@
+package nats_proxy
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// sign returns a compact serialized JWT for the claims
+func sign(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	assert.Nil(t, err)
+	payload, err := json.Marshal(claims)
+	assert.Nil(t, err)
+
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		assert.Nil(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		assert.Nil(t, err)
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(k, []byte(signed))
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	}
+
+	return signed + "." + b64(signature)
+}
+
+func TestAuth(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	secret := []byte("secret")
+
+	jwks, err := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+			{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64(edPublic)},
+			{"kty": "oct", "kid": "hs", "k": b64(secret)},
+		},
+	})
+	assert.Nil(t, err)
+
+	dir, err := ioutil.TempDir("", "auth")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "jwks.json")
+	assert.Nil(t, ioutil.WriteFile(filename, jwks, 0600))
+
+	var received *Identity
+	h := func(ctx context.Context, subject string, message *Message) (*Message, error) {
+		data, err := proto.Marshal(message)
+		assert.Nil(t, err)
+		m := &Message{}
+		assert.Nil(t, proto.Unmarshal(data, m))
+
+		req, err := requestFromMessage(m, "", subject)
+		assert.Nil(t, err)
+		received, _ = RequestIdentity(withIdentity(req, m.Identity))
+		return &Message{Status: http.StatusOK}, nil
+	}
+
+	auth := NewAuth(
+		JWT(NewJWKS(filename, 0), JWTIssuer("issuer"), JWTAudience("gateway")),
+		APIKey("", NewMemoryAPIKeyStore(map[string]*Identity{"key-a": {Subject: "service-a"}})),
+		Basic("nats-proxy", map[string]string{"alice": "password"}),
+	)
+	gw, err := NewGateway(
+		WithHandler(h),
+		WithInstrumented("auth", auth),
+	)
+	assert.Nil(t, err)
+	defer gw.Close()
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub":   "user-a",
+			"iss":   "issuer",
+			"aud":   []string{"gateway"},
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"roles": []string{"admin"},
+		}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	testCases := map[string]struct {
+		Header  map[string]string
+		Status  int
+		Subject string
+		Method  string
+	}{
+		"rs256": {
+			Header:  map[string]string{"Authorization": "Bearer " + sign(t, "RS256", "rsa", rsaKey, claims(nil))},
+			Status:  http.StatusOK,
+			Subject: "user-a",
+			Method:  "jwt",
+		},
+		"es256": {
+			Header:  map[string]string{"Authorization": "Bearer " + sign(t, "ES256", "ec", ecKey, claims(nil))},
+			Status:  http.StatusOK,
+			Subject: "user-a",
+			Method:  "jwt",
+		},
+		"eddsa": {
+			Header:  map[string]string{"Authorization": "Bearer " + sign(t, "EdDSA", "ed", edKey, claims(nil))},
+			Status:  http.StatusOK,
+			Subject: "user-a",
+			Method:  "jwt",
+		},
+		"hs256": {
+			Header:  map[string]string{"Authorization": "Bearer " + sign(t, "HS256", "hs", secret, claims(nil))},
+			Status:  http.StatusOK,
+			Subject: "user-a",
+			Method:  "jwt",
+		},
+		"wrong key": {
+			Header: map[string]string{"Authorization": "Bearer " + sign(t, "HS256", "rsa", secret, claims(nil))},
+			Status: http.StatusUnauthorized,
+		},
+		"expired": {
+			Header: map[string]string{"Authorization": "Bearer " + sign(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}))},
+			Status: http.StatusUnauthorized,
+		},
+		"wrong issuer": {
+			Header: map[string]string{"Authorization": "Bearer " + sign(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"iss": "other"}))},
+			Status: http.StatusUnauthorized,
+		},
+		"wrong audience": {
+			Header: map[string]string{"Authorization": "Bearer " + sign(t, "RS256", "rsa", rsaKey, claims(map[string]interface{}{"aud": "other"}))},
+			Status: http.StatusUnauthorized,
+		},
+		"api key": {
+			Header:  map[string]string{"X-Api-Key": "key-a"},
+			Status:  http.StatusOK,
+			Subject: "service-a",
+			Method:  "apikey",
+		},
+		"unknown api key": {
+			Header: map[string]string{"X-Api-Key": "key-b"},
+			Status: http.StatusUnauthorized,
+		},
+		"basic": {
+			Header:  map[string]string{"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:password"))},
+			Status:  http.StatusOK,
+			Subject: "alice",
+			Method:  "basic",
+		},
+		"basic wrong password": {
+			Header: map[string]string{"Authorization": "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:wrong"))},
+			Status: http.StatusUnauthorized,
+		},
+		"none": {
+			Status: http.StatusUnauthorized,
+		},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			received = nil
+
+			req := httptest.NewRequest("GET", "http://localhost/foo", nil)
+			for k, v := range tc.Header {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			gw.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.Status, w.Code)
+			if tc.Status == http.StatusOK {
+				assert.NotNil(t, received)
+				assert.Equal(t, tc.Subject, received.Subject)
+				assert.Equal(t, tc.Method, received.Method)
+			} else {
+				assert.Equal(t, `Bearer, Basic realm="nats-proxy"`, w.Header().Get("WWW-Authenticate"))
+			}
+			if tc.Method == "jwt" {
+				assert.Equal(t, `["admin"]`, received.Claims["roles"])
+			}
+		})
+	}
+
+	assert.Equal(t, AuthStats{Authenticated: 6, Rejected: 7}, auth.Stats())
+}
+
+func TestAnonymous(t *testing.T) {
+	h := func(ctx context.Context, subject string, message *Message) (*Message, error) {
+		return &Message{Status: http.StatusOK}, nil
+	}
+
+	gw, err := NewGateway(
+		WithHandler(h),
+		WithFilters(Authenticate(Basic("", map[string]string{"alice": "password"}), Anonymous())),
+	)
+	assert.Nil(t, err)
+	defer gw.Close()
+
+	req := httptest.NewRequest("GET", "http://localhost/foo", nil)
+	w := httptest.NewRecorder()
+	gw.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req.SetBasicAuth("alice", "wrong")
+	w = httptest.NewRecorder()
+	gw.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestJWKSFetch(t *testing.T) {
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	set, err := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64(edPublic)}},
+	})
+	assert.Nil(t, err)
+
+	var fetches, healthy int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		if atomic.LoadInt32(&healthy) == 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(set)
+	}))
+	defer server.Close()
+
+	keys := NewJWKS(server.URL, time.Millisecond*50).(*jwks)
+
+	// concurrent requests share one fetch, made outside the lock
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := keys.Key(context.Background(), "ed")
+			errs <- err
+		}()
+	}
+	time.Sleep(time.Millisecond * 50)
+	close(release)
+	for i := 0; i < cap(errs); i++ {
+		assert.NotNil(t, <-errs)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+	// failed fetches back off before trying again
+	_, err = keys.Key(context.Background(), "ed")
+	assert.NotNil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+	atomic.StoreInt32(&healthy, 1)
+	time.Sleep(jwksMinBackoff)
+	key, err := keys.Key(context.Background(), "ed")
+	assert.Nil(t, err)
+	assert.Equal(t, edPublic, key)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+
+	// the last good keys are served while the issuer is down
+	atomic.StoreInt32(&healthy, 0)
+	time.Sleep(time.Millisecond * 60)
+	for i := 0; i < 5; i++ {
+		key, err = keys.Key(context.Background(), "ed")
+		assert.Nil(t, err)
+		assert.Equal(t, edPublic, key)
+	}
+	assert.Eventually(t, func() bool {
+		keys.mu.Lock()
+		defer keys.mu.Unlock()
+		return keys.failures == 1
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&fetches))
+}
@@ -0,0 +1,304 @@
+package nats_proxy
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBreakerFailureRate specifies the fraction of failed requests within a window that opens the breaker
+	DefaultBreakerFailureRate = 0.5
+
+	// DefaultBreakerMinRequests specifies how many requests a window must contain before the breaker may open
+	DefaultBreakerMinRequests = 20
+
+	// DefaultBreakerWindow specifies the duration over which failures are counted
+	DefaultBreakerWindow = time.Second * 10
+
+	// DefaultBreakerCoolDown specifies how long the breaker stays open before allowing probe requests through
+	DefaultBreakerCoolDown = time.Second * 5
+
+	// maxIdleKeys bounds the number of per-key entries, such as circuits, retained before idle entries are discarded
+	maxIdleKeys = 1024
+)
+
+// BreakerState is the state of a single circuit
+type BreakerState int
+
+const (
+	// BreakerClosed allows all requests through
+	BreakerClosed BreakerState = iota
+	// BreakerOpen rejects all requests
+	BreakerOpen
+	// BreakerHalfOpen allows a single probe request through to test whether the subject has recovered
+	BreakerHalfOpen
+)
+
+// String implements fmt.Stringer
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText allows the state to be rendered by name on the admin api
+func (s BreakerState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// BreakerOption configures a CircuitBreaker
+type BreakerOption func(*CircuitBreaker)
+
+// BreakerFailureRate specifies the fraction of failed requests, once a window contains at least minRequests, that
+// opens the breaker; defaults to ```nats_proxy.DefaultBreakerFailureRate``` and
+// ```nats_proxy.DefaultBreakerMinRequests```
+func BreakerFailureRate(rate float64, minRequests int) BreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.failureRate = rate
+		cb.minRequests = minRequests
+	}
+}
+
+// BreakerWindow specifies the duration over which failures are counted; defaults to
+// ```nats_proxy.DefaultBreakerWindow```
+func BreakerWindow(d time.Duration) BreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.window = d
+	}
+}
+
+// BreakerCoolDown specifies how long an open breaker rejects requests before allowing a probe through; defaults to
+// ```nats_proxy.DefaultBreakerCoolDown```
+func BreakerCoolDown(d time.Duration) BreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.coolDown = d
+	}
+}
+
+// BreakerKey specifies how subjects are grouped into circuits; by default each subject has its own circuit
+func BreakerKey(fn func(subject string) string) BreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.key = fn
+	}
+}
+
+// SubjectPrefix returns a key func that groups subjects by their first n tokens e.g. SubjectPrefix(2) maps
+// api.foo.bar to api.foo; useful with BreakerKey to break by service rather than by route
+func SubjectPrefix(n int) func(subject string) string {
+	return func(subject string) string {
+		tokens := strings.SplitN(subject, ".", n+1)
+		if len(tokens) > n {
+			tokens = tokens[:n]
+		}
+		return strings.Join(tokens, ".")
+	}
+}
+
+// CircuitStats reports the state of a single circuit
+type CircuitStats struct {
+	State    BreakerState `json:"state"`
+	Requests int          `json:"requests"`
+	Failures int          `json:"failures"`
+	OpenedAt *time.Time   `json:"opened_at,omitempty"`
+}
+
+type circuit struct {
+	state       BreakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probing     bool
+}
+
+// CircuitBreaker rejects requests with 503 Service Unavailable while the subject they target is failing, rather than
+// allowing each request to wait for the full timeout.  Timeouts, missing responders and 5xx replies count as failures;
+// errors caused by the request itself, such as a body too large to publish, say nothing of the subject and do not
+type CircuitBreaker struct {
+	failureRate float64
+	minRequests int
+	window      time.Duration
+	coolDown    time.Duration
+	key         func(subject string) string
+	mu          sync.Mutex
+	circuits    map[string]*circuit
+}
+
+// NewCircuitBreaker returns a new CircuitBreaker; register it with WithInstrumented to report circuit state on the
+// admin api
+func NewCircuitBreaker(opts ...BreakerOption) *CircuitBreaker {
+	cb := &CircuitBreaker{
+		failureRate: DefaultBreakerFailureRate,
+		minRequests: DefaultBreakerMinRequests,
+		window:      DefaultBreakerWindow,
+		coolDown:    DefaultBreakerCoolDown,
+		key:         func(subject string) string { return subject },
+		circuits:    map[string]*circuit{},
+	}
+
+	for _, opt := range opts {
+		opt(cb)
+	}
+
+	return cb
+}
+
+// Stats returns the state of each circuit keyed by subject
+func (cb *CircuitBreaker) Stats() interface{} {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	stats := map[string]CircuitStats{}
+	for key, c := range cb.circuits {
+		s := CircuitStats{
+			State:    c.state,
+			Requests: c.requests,
+			Failures: c.failures,
+		}
+		if c.state != BreakerClosed {
+			openedAt := c.openedAt
+			s.OpenedAt = &openedAt
+		}
+		stats[key] = s
+	}
+	return stats
+}
+
+// Filter returns the Filter that enforces the breaker
+func (cb *CircuitBreaker) Filter() Filter {
+	return func(h Handler) Handler {
+		return func(ctx context.Context, subject string, message *Message) (*Message, error) {
+			key := cb.key(subject)
+
+			if retryAfter, ok := cb.allow(key, time.Now()); !ok {
+				return &Message{
+					Status: http.StatusServiceUnavailable,
+					Header: map[string]string{
+						"Content-Type": "text/plain; charset=utf-8",
+						"Retry-After":  strconv.Itoa(int(retryAfter/time.Second) + 1),
+					},
+					Body: []byte("circuit open for " + key + "\n"),
+				}, nil
+			}
+
+			out, err := h(ctx, subject, message)
+
+			switch {
+			case err != nil && ctx.Err() == context.Canceled:
+				cb.release(key) // the client went away; says nothing about the health of the subject
+			case isUnavailable(err):
+				cb.record(key, time.Now(), true)
+			case err != nil:
+				cb.release(key) // caused by the request e.g. a body too large to publish
+			default:
+				cb.record(key, time.Now(), out != nil && out.Status >= http.StatusInternalServerError)
+			}
+
+			return out, err
+		}
+	}
+}
+
+// allow determines whether a request may proceed; if not, returns how long until a probe will be allowed
+func (cb *CircuitBreaker) allow(key string, now time.Time) (time.Duration, bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	c, ok := cb.circuits[key]
+	if !ok {
+		if len(cb.circuits) >= maxIdleKeys {
+			cb.sweep(now)
+		}
+		c = &circuit{windowStart: now}
+		cb.circuits[key] = c
+	}
+
+	switch c.state {
+	case BreakerOpen:
+		if remaining := c.openedAt.Add(cb.coolDown).Sub(now); remaining > 0 {
+			return remaining, false
+		}
+		c.state = BreakerHalfOpen
+		c.probing = true
+		return 0, true
+
+	case BreakerHalfOpen:
+		if c.probing {
+			return cb.coolDown, false
+		}
+		c.probing = true
+		return 0, true
+
+	default:
+		if now.Sub(c.windowStart) > cb.window {
+			c.windowStart = now
+			c.requests = 0
+			c.failures = 0
+		}
+		return 0, true
+	}
+}
+
+func (cb *CircuitBreaker) record(key string, now time.Time, failed bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	c, ok := cb.circuits[key]
+	if !ok {
+		return // swept while the request was in flight
+	}
+
+	switch c.state {
+	case BreakerHalfOpen:
+		c.probing = false
+		if failed {
+			c.state = BreakerOpen
+			c.openedAt = now
+			return
+		}
+		c.state = BreakerClosed
+		c.windowStart = now
+		c.requests = 0
+		c.failures = 0
+
+	case BreakerClosed:
+		c.requests++
+		if failed {
+			c.failures++
+		}
+		if failed && c.requests >= cb.minRequests && float64(c.failures) >= cb.failureRate*float64(c.requests) {
+			c.state = BreakerOpen
+			c.openedAt = now
+		}
+	}
+}
+
+// sweep discards closed circuits whose window has expired; subjects are derived from request paths so the set of
+// keys is unbounded
+func (cb *CircuitBreaker) sweep(now time.Time) {
+	for key, c := range cb.circuits {
+		if c.state == BreakerClosed && now.Sub(c.windowStart) > cb.window {
+			delete(cb.circuits, key)
+		}
+	}
+}
+
+func (cb *CircuitBreaker) release(key string) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if c, ok := cb.circuits[key]; ok && c.state == BreakerHalfOpen {
+		c.probing = false
+	}
+}
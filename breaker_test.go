@@ -0,0 +1,77 @@
+package nats_proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var err error
+	h := func(ctx context.Context, subject string, message *Message) (*Message, error) {
+		if err != nil {
+			return nil, err
+		}
+		return &Message{Status: http.StatusOK}, nil
+	}
+
+	cb := NewCircuitBreaker(
+		BreakerFailureRate(0.5, 4),
+		BreakerCoolDown(time.Millisecond*50),
+	)
+	fn := cb.Filter()(h)
+	call := func() int32 {
+		out, err := fn(context.Background(), "api.foo", &Message{})
+		if err != nil {
+			return http.StatusGatewayTimeout
+		}
+		return out.Status
+	}
+	state := func() BreakerState {
+		return cb.Stats().(map[string]CircuitStats)["api.foo"].State
+	}
+
+	// closed
+	assert.Equal(t, int32(http.StatusOK), call())
+	assert.Equal(t, int32(http.StatusOK), call())
+
+	err = nats.ErrTimeout
+	assert.Equal(t, int32(http.StatusGatewayTimeout), call())
+	assert.Equal(t, BreakerClosed, state())
+	assert.Equal(t, int32(http.StatusGatewayTimeout), call())
+
+	// open
+	assert.Equal(t, BreakerOpen, state())
+	assert.Equal(t, int32(http.StatusServiceUnavailable), call())
+
+	// half-open probe fails
+	time.Sleep(time.Millisecond * 60)
+	assert.Equal(t, int32(http.StatusGatewayTimeout), call())
+	assert.Equal(t, BreakerOpen, state())
+
+	// half-open probe succeeds
+	err = nil
+	time.Sleep(time.Millisecond * 60)
+	assert.Equal(t, int32(http.StatusOK), call())
+	assert.Equal(t, BreakerClosed, state())
+
+	// errors caused by the request do not open the circuit
+	err = ErrBodyTooLarge
+	for i := 0; i < 10; i++ {
+		call()
+	}
+	assert.Equal(t, BreakerClosed, state())
+
+	data, _ := json.Marshal(cb.Stats())
+	assert.Contains(t, string(data), `"state":"closed"`)
+}
+
+func TestSubjectPrefix(t *testing.T) {
+	assert.Equal(t, "api.foo", SubjectPrefix(2)("api.foo.bar.baz"))
+	assert.Equal(t, "api", SubjectPrefix(2)("api"))
+}
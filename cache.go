@@ -0,0 +1,430 @@
+package nats_proxy
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultCacheEntries specifies the number of responses retained by the in-memory cache store
+	DefaultCacheEntries = 1024
+
+	// DefaultCacheMaxBody specifies the largest response body the cache will store
+	DefaultCacheMaxBody = 1 << 20
+
+	// CacheStatusHeader reports whether the response was served from the cache: HIT, MISS, REVALIDATED or BYPASS
+	CacheStatusHeader = "X-Cache"
+)
+
+// CachedResponse is a response held by a CacheStore
+type CachedResponse struct {
+	Status  int32             `json:"status"`
+	Header  map[string]string `json:"header,omitempty"`
+	Body    []byte            `json:"body,omitempty"`
+	Stored  time.Time         `json:"stored"`
+	Expires time.Time         `json:"expires"`
+	Vary    map[string]string `json:"vary,omitempty"` // values of the request headers the response varies on
+}
+
+func (c *CachedResponse) fresh(now time.Time) bool {
+	return now.Before(c.Expires)
+}
+
+// message returns a copy of the cached response as a *Message
+func (c *CachedResponse) message(now time.Time, status string) *Message {
+	header := make(map[string]string, len(c.Header)+2)
+	for k, v := range c.Header {
+		header[k] = v
+	}
+	header["Age"] = strconv.Itoa(int(now.Sub(c.Stored) / time.Second))
+	header[CacheStatusHeader] = status
+
+	return &Message{
+		Status: c.Status,
+		Header: header,
+		Body:   c.Body,
+	}
+}
+
+// CacheStore holds cached responses; a nil response and nil error indicate a miss
+type CacheStore interface {
+	Get(ctx context.Context, key string) (*CachedResponse, error)
+	Set(ctx context.Context, key string, response *CachedResponse) error
+}
+
+type lruEntry struct {
+	key      string
+	response *CachedResponse
+}
+
+type memoryCacheStore struct {
+	mu      sync.Mutex
+	max     int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// NewMemoryCacheStore returns a CacheStore local to this process that retains up to max responses, discarding the
+// least recently used
+func NewMemoryCacheStore(max int) CacheStore {
+	if max <= 0 {
+		max = DefaultCacheEntries
+	}
+
+	return &memoryCacheStore{
+		max:     max,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+func (m *memoryCacheStore) Get(_ context.Context, key string) (*CachedResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	element, ok := m.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	m.order.MoveToFront(element)
+	return element.Value.(*lruEntry).response, nil
+}
+
+func (m *memoryCacheStore) Set(_ context.Context, key string, response *CachedResponse) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if element, ok := m.entries[key]; ok {
+		element.Value.(*lruEntry).response = response
+		m.order.MoveToFront(element)
+		return nil
+	}
+
+	m.entries[key] = m.order.PushFront(&lruEntry{key: key, response: response})
+	for m.order.Len() > m.max {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*lruEntry).key)
+	}
+
+	return nil
+}
+
+type kvCacheStore struct {
+	kv KeyValue
+}
+
+// NewKVCacheStore returns a CacheStore that keeps responses in a KeyValue, such as NewKeyValue, so the cache is shared
+// across gateway replicas.  The TTL of the bucket bounds how long responses are retained
+func NewKVCacheStore(kv KeyValue) CacheStore {
+	return &kvCacheStore{kv: kv}
+}
+
+// kvKey hashes the cache key into a valid subject token
+func kvKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (k *kvCacheStore) Get(ctx context.Context, key string) (*CachedResponse, error) {
+	data, _, err := k.kv.Get(ctx, kvKey(key))
+	if err == ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	response := &CachedResponse{}
+	if err := json.Unmarshal(data, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (k *kvCacheStore) Set(ctx context.Context, key string, response *CachedResponse) error {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+
+	_, err = k.kv.Put(ctx, kvKey(key), data)
+	return err
+}
+
+// parseCacheControl returns the directives of a Cache-Control header keyed by lower case name
+func parseCacheControl(v string) map[string]string {
+	directives := map[string]string{}
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if segments := strings.SplitN(item, "=", 2); len(segments) == 2 {
+			directives[strings.ToLower(segments[0])] = strings.Trim(segments[1], `"`)
+		} else {
+			directives[strings.ToLower(item)] = ""
+		}
+	}
+	return directives
+}
+
+// cacheableStatus lists the statuses the cache will store
+var cacheableStatus = map[int32]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+// expires returns when the response becomes stale and whether it may be stored at all.  Responses without explicit
+// freshness are only stored if they carry an ETag, and are then revalidated on every use
+func expires(out *Message, now time.Time) (time.Time, bool) {
+	if !cacheableStatus[out.Status] && out.Status != 0 {
+		return time.Time{}, false
+	}
+
+	if out.Header["Vary"] == "*" {
+		return time.Time{}, false
+	}
+
+	// cookies are set for one client and must never be replayed to another
+	if len(out.Cookies) > 0 || out.Header["Set-Cookie"] != "" {
+		return time.Time{}, false
+	}
+
+	cc := parseCacheControl(out.Header["Cache-Control"])
+	if _, ok := cc["no-store"]; ok {
+		return time.Time{}, false
+	}
+	if _, ok := cc["private"]; ok {
+		return time.Time{}, false
+	}
+	if _, ok := cc["no-cache"]; ok {
+		return now, out.Header["Etag"] != ""
+	}
+
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[directive]; ok {
+			if seconds, err := strconv.Atoi(v); err == nil {
+				return now.Add(time.Duration(seconds) * time.Second), true
+			}
+		}
+	}
+
+	if v := out.Header["Expires"]; v != "" {
+		if t, err := http.ParseTime(v); err == nil {
+			return t, true
+		}
+		return now, out.Header["Etag"] != "" // invalid dates mean already expired
+	}
+
+	return now, out.Header["Etag"] != ""
+}
+
+// public returns true if a response to a private request may be stored by a shared cache
+func public(out *Message) bool {
+	cc := parseCacheControl(out.Header["Cache-Control"])
+	_, isPublic := cc["public"]
+	_, sMaxAge := cc["s-maxage"]
+	_, mustRevalidate := cc["must-revalidate"]
+	return isPublic || sMaxAge || mustRevalidate
+}
+
+// private returns true if the response to the request may be specific to the user making it, because the request
+// carries credentials or forwards cookies to the service
+func private(req *http.Request, message *Message) bool {
+	return message.Identity != nil || req.Header.Get("Authorization") != "" || len(message.Cookies) > 0
+}
+
+// varyValues captures the values of the request headers named by the response's Vary header
+func varyValues(vary string, req *http.Request) map[string]string {
+	if vary == "" {
+		return nil
+	}
+
+	values := map[string]string{}
+	for _, name := range strings.Split(vary, ",") {
+		if name = http.CanonicalHeaderKey(strings.TrimSpace(name)); name != "" {
+			values[name] = req.Header.Get(name)
+		}
+	}
+	return values
+}
+
+func varyMatches(response *CachedResponse, req *http.Request) bool {
+	for name, value := range response.Vary {
+		if req.Header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// CacheStats reports the Cache's activity
+type CacheStats struct {
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Revalidated uint64 `json:"revalidated"`
+	Errors      uint64 `json:"errors"`
+}
+
+// Cache serves GET and HEAD requests from a CacheStore following HTTP caching semantics: freshness from the
+// Cache-Control and Expires response headers, Vary, and revalidation of stale responses with If-None-Match.  Responses
+// are keyed by subject and query string and report their status in the X-Cache header.  Responses to requests that
+// carry credentials or forward cookies are only stored if marked public, s-maxage or must-revalidate; register Auth
+// ahead of the Cache so cached responses are not served to unauthenticated clients
+type Cache struct {
+	hits        uint64 // accessed atomically
+	misses      uint64 // accessed atomically
+	revalidated uint64 // accessed atomically
+	errors      uint64 // accessed atomically
+	store       CacheStore
+}
+
+// NewCache returns a new Cache using the specified store; a nil store uses NewMemoryCacheStore.  Register the Cache
+// with WithInstrumented to report its stats on the admin api
+func NewCache(store CacheStore) *Cache {
+	if store == nil {
+		store = NewMemoryCacheStore(DefaultCacheEntries)
+	}
+
+	return &Cache{
+		store: store,
+	}
+}
+
+// Stats returns a snapshot of the Cache's activity
+func (c *Cache) Stats() interface{} {
+	return CacheStats{
+		Hits:        atomic.LoadUint64(&c.hits),
+		Misses:      atomic.LoadUint64(&c.misses),
+		Revalidated: atomic.LoadUint64(&c.revalidated),
+		Errors:      atomic.LoadUint64(&c.errors),
+	}
+}
+
+// Filter returns the Filter that serves and stores cached responses
+func (c *Cache) Filter() Filter {
+	return func(h Handler) Handler {
+		return func(ctx context.Context, subject string, message *Message) (*Message, error) {
+			if message == nil || (message.Method != http.MethodGet && message.Method != http.MethodHead) {
+				return h(ctx, subject, message)
+			}
+
+			req, ok := HTTPRequest(ctx)
+			if !ok {
+				return h(ctx, subject, message)
+			}
+
+			requestCC := parseCacheControl(req.Header.Get("Cache-Control"))
+			if _, ok := requestCC["no-store"]; ok {
+				out, err := h(ctx, subject, message)
+				if out != nil {
+					setHeader(out, CacheStatusHeader, "BYPASS")
+				}
+				return out, err
+			}
+
+			key := message.Method + " " + subject + "?" + req.URL.RawQuery
+			now := time.Now()
+
+			cached, err := c.store.Get(ctx, key)
+			if err != nil {
+				atomic.AddUint64(&c.errors, 1)
+				log.Printf("Unable to read from cache, %v\n", err)
+			}
+			if cached != nil && !varyMatches(cached, req) {
+				cached = nil
+			}
+
+			_, noCache := requestCC["no-cache"]
+			if cached != nil && cached.fresh(now) && !noCache {
+				atomic.AddUint64(&c.hits, 1)
+				if etag := cached.Header["Etag"]; etag != "" && req.Header.Get("If-None-Match") == etag {
+					return &Message{
+						Status: http.StatusNotModified,
+						Header: map[string]string{"Etag": etag, CacheStatusHeader: "HIT"},
+					}, nil
+				}
+				return cached.message(now, "HIT"), nil
+			}
+
+			if cached != nil && cached.Header["Etag"] != "" {
+				if message.Header == nil {
+					message.Header = map[string]string{}
+				}
+				message.Header["If-None-Match"] = cached.Header["Etag"]
+			}
+
+			out, err := h(ctx, subject, message)
+			if err != nil {
+				return nil, err
+			}
+
+			if cached != nil && out.Status == http.StatusNotModified {
+				atomic.AddUint64(&c.revalidated, 1)
+
+				refreshed := *cached
+				refreshed.Header = copyHeader(cached.Header)
+				for k, v := range out.Header {
+					refreshed.Header[k] = v
+				}
+				if expiry, ok := expires(&Message{Status: refreshed.Status, Header: refreshed.Header}, now); ok {
+					refreshed.Stored = now
+					refreshed.Expires = expiry
+					c.set(ctx, key, &refreshed)
+				}
+				return refreshed.message(now, "REVALIDATED"), nil
+			}
+
+			atomic.AddUint64(&c.misses, 1)
+			if expiry, ok := expires(out, now); ok && len(out.Body) <= DefaultCacheMaxBody && (!private(req, message) || public(out)) {
+				c.set(ctx, key, &CachedResponse{
+					Status:  out.Status,
+					Header:  copyHeader(out.Header),
+					Body:    out.Body,
+					Stored:  now,
+					Expires: expiry,
+					Vary:    varyValues(out.Header["Vary"], req),
+				})
+			}
+			setHeader(out, CacheStatusHeader, "MISS")
+			return out, nil
+		}
+	}
+}
+
+func (c *Cache) set(ctx context.Context, key string, response *CachedResponse) {
+	if err := c.store.Set(ctx, key, response); err != nil {
+		atomic.AddUint64(&c.errors, 1)
+		log.Printf("Unable to write to cache, %v\n", err)
+	}
+}
+
+func copyHeader(header map[string]string) map[string]string {
+	c := make(map[string]string, len(header))
+	for k, v := range header {
+		c[k] = v
+	}
+	return c
+}
+
+func setHeader(m *Message, key, value string) {
+	if m.Header == nil {
+		m.Header = map[string]string{}
+	}
+	m.Header[key] = value
+}
@@ -0,0 +1,155 @@
+package nats_proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	calls := 0
+	h := func(ctx context.Context, subject string, message *Message) (*Message, error) {
+		calls++
+		if message.Header["If-None-Match"] == `"v1"` {
+			return &Message{Status: http.StatusNotModified, Header: map[string]string{"Cache-Control": "max-age=60"}}, nil
+		}
+		return &Message{
+			Status: http.StatusOK,
+			Header: map[string]string{
+				"Cache-Control": "max-age=0",
+				"Etag":          `"v1"`,
+				"Vary":          "Accept-Language",
+			},
+			Body: []byte("hello"),
+		}, nil
+	}
+
+	for name, store := range map[string]CacheStore{
+		"memory": NewMemoryCacheStore(10),
+		"kv":     NewKVCacheStore(newMemoryKV()),
+	} {
+		t.Run(name, func(t *testing.T) {
+			calls = 0
+			cache := NewCache(store)
+			gw, err := NewGateway(
+				WithHandler(h),
+				WithInstrumented("cache", cache),
+			)
+			assert.Nil(t, err)
+			defer gw.Close()
+
+			call := func(language, ifNoneMatch string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest("GET", "http://localhost/foo?a=b", nil)
+				req.Header.Set("Accept-Language", language)
+				if ifNoneMatch != "" {
+					req.Header.Set("If-None-Match", ifNoneMatch)
+				}
+				w := httptest.NewRecorder()
+				gw.ServeHTTP(w, req)
+				return w
+			}
+
+			// stored, but stale immediately
+			w := call("en", "")
+			assert.Equal(t, "MISS", w.Header().Get(CacheStatusHeader))
+			assert.Equal(t, "hello", w.Body.String())
+
+			// revalidated with the service; now fresh for 60s
+			w = call("en", "")
+			assert.Equal(t, "REVALIDATED", w.Header().Get(CacheStatusHeader))
+			assert.Equal(t, "hello", w.Body.String())
+			assert.Equal(t, 2, calls)
+
+			w = call("en", "")
+			assert.Equal(t, "HIT", w.Header().Get(CacheStatusHeader))
+			assert.Equal(t, "hello", w.Body.String())
+			assert.Equal(t, 2, calls)
+
+			// conditional request served from the cache
+			w = call("en", `"v1"`)
+			assert.Equal(t, http.StatusNotModified, w.Code)
+			assert.Equal(t, 2, calls)
+
+			// varies on Accept-Language
+			w = call("fr", "")
+			assert.Equal(t, "MISS", w.Header().Get(CacheStatusHeader))
+			assert.Equal(t, 3, calls)
+
+			assert.Equal(t, CacheStats{Hits: 2, Misses: 2, Revalidated: 1}, cache.Stats())
+		})
+	}
+}
+
+func TestCachePrivate(t *testing.T) {
+	calls := 0
+	h := func(ctx context.Context, subject string, message *Message) (*Message, error) {
+		calls++
+		cacheControl := "max-age=60"
+		if subject == "api.shared" {
+			cacheControl = "public, max-age=60"
+		}
+		return &Message{Status: http.StatusOK, Header: map[string]string{"Cache-Control": cacheControl}, Body: []byte("hello")}, nil
+	}
+
+	gw, err := NewGateway(
+		WithHandler(h),
+		WithCookies("session"),
+		WithInstrumented("cache", NewCache(NewMemoryCacheStore(10))),
+	)
+	assert.Nil(t, err)
+	defer gw.Close()
+
+	call := func(path string, decorate func(req *http.Request)) string {
+		req := httptest.NewRequest("GET", "http://localhost"+path, nil)
+		decorate(req)
+		w := httptest.NewRecorder()
+		gw.ServeHTTP(w, req)
+		return w.Header().Get(CacheStatusHeader)
+	}
+	authorization := func(req *http.Request) { req.Header.Set("Authorization", "Bearer alice") }
+	cookie := func(req *http.Request) { req.AddCookie(&http.Cookie{Name: "session", Value: "alice"}) }
+
+	for label, decorate := range map[string]func(*http.Request){"authorization": authorization, "cookie": cookie} {
+		t.Run(label, func(t *testing.T) {
+			assert.Equal(t, "MISS", call("/"+label, decorate))
+			assert.Equal(t, "MISS", call("/"+label, decorate))
+		})
+	}
+
+	t.Run("public", func(t *testing.T) {
+		assert.Equal(t, "MISS", call("/shared", authorization))
+		assert.Equal(t, "HIT", call("/shared", cookie))
+	})
+}
+
+func TestExpires(t *testing.T) {
+	now := time.Now()
+
+	testCases := map[string]struct {
+		Header  map[string]string
+		Expires time.Time
+		OK      bool
+	}{
+		"max-age":  {Header: map[string]string{"Cache-Control": "public, max-age=10"}, Expires: now.Add(time.Second * 10), OK: true},
+		"s-maxage": {Header: map[string]string{"Cache-Control": "max-age=10, s-maxage=20"}, Expires: now.Add(time.Second * 20), OK: true},
+		"no-store": {Header: map[string]string{"Cache-Control": "no-store, max-age=10"}},
+		"private":  {Header: map[string]string{"Cache-Control": "private, max-age=10"}},
+		"etag":     {Header: map[string]string{"Etag": `"x"`}, Expires: now, OK: true},
+		"none":     {Header: map[string]string{}},
+		"cookie":   {Header: map[string]string{"Cache-Control": "public, max-age=10", "Set-Cookie": "a=b"}},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			expiry, ok := expires(&Message{Status: http.StatusOK, Header: tc.Header}, now)
+			assert.Equal(t, tc.OK, ok)
+			if ok {
+				assert.Equal(t, tc.Expires, expiry)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package nats_proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// parseTrustedProxies parses ip addresses and cidr ranges e.g. 10.0.0.0/8 or 192.168.1.10
+func parseTrustedProxies(values []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, errors.Errorf("invalid trusted proxy, %v", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid trusted proxy, %v", value)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// trusted returns true if the ip address belongs to a trusted proxy
+func (p *Gateway) trusted(addr string) bool {
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	if ip == nil {
+		return false
+	}
+	for _, network := range p.proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// client describes the client connection of the request.  When the request arrives from a trusted proxy, the client
+// address is the last untrusted address in X-Forwarded-For, and X-Forwarded-Proto and X-Forwarded-Host are honored;
+// otherwise these headers are ignored as any client may set them
+func (p *Gateway) client(req *http.Request) *Client {
+	client := &Client{
+		RemoteAddr: req.RemoteAddr,
+		Host:       req.Host,
+		Scheme:     "http",
+		Proto:      req.Proto,
+	}
+
+	if state := req.TLS; state != nil {
+		client.Scheme = "https"
+		client.Tls = &ClientTLS{
+			Version:            uint32(state.Version),
+			CipherSuite:        uint32(state.CipherSuite),
+			ServerName:         state.ServerName,
+			NegotiatedProtocol: state.NegotiatedProtocol,
+		}
+		// only certificates the server verified; unverified certificates may claim any subject
+		if len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 0 {
+			cert := state.VerifiedChains[0][0]
+			client.Tls.PeerSubject = cert.Subject.String()
+			client.Tls.PeerCertificate = cert.Raw
+		}
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil || !p.trusted(host) {
+		return client
+	}
+
+	// walk X-Forwarded-For from the nearest hop, skipping our own proxies; the first untrusted address is the client
+	var forwarded []string
+	for _, value := range req.Header["X-Forwarded-For"] {
+		forwarded = append(forwarded, strings.Split(value, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(addr) == nil {
+			break
+		}
+		client.RemoteAddr = net.JoinHostPort(addr, "0")
+		if !p.trusted(addr) {
+			break
+		}
+	}
+
+	if proto := firstValue(req.Header.Get("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+		client.Scheme = proto
+	}
+	if host := firstValue(req.Header.Get("X-Forwarded-Host")); host != "" {
+		client.Host = host
+	}
+
+	return client
+}
+
+// firstValue returns the first item of a comma separated header value
+func firstValue(value string) string {
+	if i := strings.Index(value, ","); i >= 0 {
+		value = value[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+// applyClient populates the reconstructed request with the client connection described by the Gateway
+func applyClient(req *http.Request, client *Client) {
+	if client.RemoteAddr != "" {
+		req.RemoteAddr = client.RemoteAddr
+	}
+	if client.Host != "" {
+		req.Host = client.Host
+		req.URL.Host = client.Host
+	}
+	if client.Scheme != "" {
+		req.URL.Scheme = client.Scheme
+	}
+	if major, minor, ok := http.ParseHTTPVersion(client.Proto); ok {
+		req.Proto, req.ProtoMajor, req.ProtoMinor = client.Proto, major, minor
+	}
+
+	// req.TLS describes the connection to the Gateway; if tls was terminated in front of the Gateway, it is left nil and
+	// handlers see https in req.URL.Scheme
+	if client.Tls != nil {
+		state := &tls.ConnectionState{
+			Version:            uint16(client.Tls.Version),
+			HandshakeComplete:  true,
+			CipherSuite:        uint16(client.Tls.CipherSuite),
+			ServerName:         client.Tls.ServerName,
+			NegotiatedProtocol: client.Tls.NegotiatedProtocol,
+		}
+		if cert, err := x509.ParseCertificate(client.Tls.PeerCertificate); err == nil {
+			state.PeerCertificates = []*x509.Certificate{cert}
+		}
+		req.TLS = state
+	}
+}
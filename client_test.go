@@ -0,0 +1,112 @@
+package nats_proxy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.10"})
+	assert.Nil(t, err)
+	gw := &Gateway{proxies: proxies}
+
+	newRequest := func(remoteAddr, forwardedFor string) *http.Request {
+		req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		req.Header.Set("X-Forwarded-Proto", "https")
+		req.Header.Set("X-Forwarded-Host", "api.example.com")
+		return req
+	}
+
+	t.Run("untrusted", func(t *testing.T) {
+		client := gw.client(newRequest("203.0.113.1:1234", "198.51.100.1"))
+		assert.Equal(t, "203.0.113.1:1234", client.RemoteAddr)
+		assert.Equal(t, "example.com", client.Host)
+		assert.Equal(t, "http", client.Scheme)
+		assert.Equal(t, "HTTP/1.1", client.Proto)
+	})
+
+	t.Run("trusted", func(t *testing.T) {
+		client := gw.client(newRequest("10.1.2.3:1234", "1.1.1.1, 198.51.100.1, 192.168.1.10"))
+		assert.Equal(t, "198.51.100.1:0", client.RemoteAddr)
+		assert.Equal(t, "api.example.com", client.Host)
+		assert.Equal(t, "https", client.Scheme)
+	})
+
+	t.Run("only proxies", func(t *testing.T) {
+		client := gw.client(newRequest("10.1.2.3:1234", "10.0.0.1"))
+		assert.Equal(t, "10.0.0.1:0", client.RemoteAddr)
+	})
+
+	_, err = parseTrustedProxies([]string{"nonsense"})
+	assert.NotNil(t, err)
+	_, err = parseTrustedProxies([]string{"10.0.0.0/33"})
+	assert.NotNil(t, err)
+}
+
+func TestApplyClient(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "alice"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, public, private)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+
+	in := httptest.NewRequest("GET", "https://api.example.com/foo", nil)
+	in.RemoteAddr = "203.0.113.1:1234"
+	in.Proto, in.ProtoMajor, in.ProtoMinor = "HTTP/2.0", 2, 0
+	in.TLS = &tls.ConnectionState{
+		Version:          tls.VersionTLS13,
+		ServerName:       "api.example.com",
+		PeerCertificates: []*x509.Certificate{cert},
+	}
+
+	// presented, but not verified by the server
+	client := (&Gateway{}).client(in)
+	assert.Equal(t, "", client.Tls.PeerSubject)
+	assert.Nil(t, client.Tls.PeerCertificate)
+
+	in.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	client = (&Gateway{}).client(in)
+	assert.Equal(t, "CN=alice", client.Tls.PeerSubject)
+
+	req, err := requestFromMessage(&Message{Method: "GET", Client: client}, "api", "api.foo")
+	assert.Nil(t, err)
+	assert.Equal(t, "203.0.113.1:1234", req.RemoteAddr)
+	assert.Equal(t, "api.example.com", req.Host)
+	assert.Equal(t, "https", req.URL.Scheme)
+	assert.Equal(t, 2, req.ProtoMajor)
+	assert.NotNil(t, req.TLS)
+	assert.Equal(t, uint16(tls.VersionTLS13), req.TLS.Version)
+	assert.Equal(t, "api.example.com", req.TLS.ServerName)
+	assert.Equal(t, "alice", req.TLS.PeerCertificates[0].Subject.CommonName)
+
+	// tls terminated in front of the gateway
+	req, err = requestFromMessage(&Message{Method: "GET", Client: &Client{Scheme: "https"}}, "api", "api.foo")
+	assert.Nil(t, err)
+	assert.Equal(t, "https", req.URL.Scheme)
+	assert.Nil(t, req.TLS)
+
+	// messages from older gateways
+	req, err = requestFromMessage(&Message{Method: "GET"}, "api", "api.foo")
+	assert.Nil(t, err)
+	assert.Equal(t, "localhost", req.Host)
+	assert.Nil(t, req.TLS)
+}
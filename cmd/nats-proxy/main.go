@@ -6,18 +6,66 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/nats-io/nats.go"
 	"github.com/savaki/nats-proxy"
 	"github.com/urfave/cli"
 )
 
 type options struct {
-	Port    int
-	Subject string
-	Headers string
-	Cookies string
-	Set     cli.StringSlice
+	Port            int
+	Server          string
+	Subject         string
+	Headers         string
+	Cookies         string
+	HeaderRules     string
+	Rewrites        string
+	HealthPath      string
+	ReadyPath       string
+	Pings           string
+	ServicesPath    string
+	AdminAddr       string
+	AdminToken      string
+	LogLevel        string
+	RetryAttempts   int
+	Breaker         bool
+	RateLimit       string
+	RateLimitKey    string
+	RateLimitBucket string
+	Hedge           string
+	Cache           int
+	CacheBucket     string
+	Coalesce        string
+	JWKS            string
+	JWTIssuer       string
+	JWTAudience     string
+	APIKeys         string
+	APIKeyHeader    string
+	BasicAuth       string
+	Anonymous       bool
+	Policy          string
+	PolicyDryRun    bool
+	SigningKey      string
+	CORSOrigins     string
+	CORSMethods     string
+	CORSHeaders     string
+	CORSExpose      string
+	CORSCredentials bool
+	CORSMaxAge      time.Duration
+	MaxBodySize     int64
+	RouteBodySize   cli.StringSlice
+	Compression     string
+	CompressRequest bool
+	CompressReplies bool
+	Codec           string
+	TrustedProxies  string
+	Set             cli.StringSlice
+	ShutdownTimeout time.Duration
 }
 
 var opts options
@@ -32,6 +80,13 @@ func main() {
 			EnvVar:      "PORT",
 			Destination: &opts.Port,
 		},
+		cli.StringFlag{
+			Name:        "server",
+			Value:       nats.DefaultURL,
+			Usage:       "nats server url",
+			EnvVar:      "NATS_URL",
+			Destination: &opts.Server,
+		},
 		cli.StringFlag{
 			Name:        "subject",
 			Usage:       "subject root that all messages will be prefixed with",
@@ -40,24 +95,280 @@ func main() {
 		},
 		cli.StringFlag{
 			Name:        "headers",
-			Usage:       "comma separated list of HTTP headers to pass through",
+			Usage:       "comma separated list of HTTP headers to pass through; may include globs e.g. X-Custom-*",
 			EnvVar:      "HEADERS",
 			Destination: &opts.Headers,
 		},
 		cli.StringFlag{
 			Name:        "cookies",
-			Usage:       "comma separated list of HTTP cookies to pass through",
+			Usage:       "comma separated list of HTTP cookies to pass through; may include globs e.g. session_*",
 			EnvVar:      "COOKIES",
 			Destination: &opts.Cookies,
 		},
+		cli.StringFlag{
+			Name:        "header-rules",
+			Usage:       "json file of rules allowing or denying headers and cookies by pattern, direction and subject",
+			EnvVar:      "HEADER_RULES",
+			Destination: &opts.HeaderRules,
+		},
+		cli.StringFlag{
+			Name:        "rewrites",
+			Usage:       "json file of rewrites adding, setting, removing or renaming request and response headers",
+			EnvVar:      "REWRITES",
+			Destination: &opts.Rewrites,
+		},
+		cli.StringFlag{
+			Name:        "health-path",
+			Usage:       "path to serve the liveness check on e.g. /healthz; disabled if not set",
+			EnvVar:      "HEALTH_PATH",
+			Destination: &opts.HealthPath,
+		},
+		cli.StringFlag{
+			Name:        "ready-path",
+			Usage:       "path to serve the readiness check on e.g. /readyz; disabled if not set",
+			EnvVar:      "READY_PATH",
+			Destination: &opts.ReadyPath,
+		},
+		cli.StringFlag{
+			Name:        "pings",
+			Usage:       "comma separated list of subjects that must respond before the gateway reports ready",
+			EnvVar:      "PINGS",
+			Destination: &opts.Pings,
+		},
+		cli.StringFlag{
+			Name:        "services-path",
+			Usage:       "path to list the live services on; disabled if not set",
+			EnvVar:      "SERVICES_PATH",
+			Destination: &opts.ServicesPath,
+		},
+		cli.StringFlag{
+			Name:        "admin-addr",
+			Usage:       "address for the admin api to listen on e.g. 127.0.0.1:5051; disabled if not set",
+			EnvVar:      "ADMIN_ADDR",
+			Destination: &opts.AdminAddr,
+		},
+		cli.StringFlag{
+			Name:        "admin-token",
+			Usage:       "bearer token required to access the admin api",
+			EnvVar:      "ADMIN_TOKEN",
+			Destination: &opts.AdminToken,
+		},
+		cli.StringFlag{
+			Name:        "log-level",
+			Value:       "error",
+			Usage:       "log level; one of debug, info, error, off",
+			EnvVar:      "LOG_LEVEL",
+			Destination: &opts.LogLevel,
+		},
+		cli.IntFlag{
+			Name:        "retry-attempts",
+			Usage:       "maximum attempts for idempotent requests that time out or find no responders; disabled if less than 2",
+			EnvVar:      "RETRY_ATTEMPTS",
+			Destination: &opts.RetryAttempts,
+		},
+		cli.BoolFlag{
+			Name:        "breaker",
+			Usage:       "enable a circuit breaker per service i.e. per {subject}.{first path segment}",
+			EnvVar:      "BREAKER",
+			Destination: &opts.Breaker,
+		},
+		cli.StringFlag{
+			Name:        "rate-limit",
+			Usage:       "requests allowed per client as requests/duration[,burst] e.g. 100/1m; disabled if not set",
+			EnvVar:      "RATE_LIMIT",
+			Destination: &opts.RateLimit,
+		},
+		cli.StringFlag{
+			Name:        "rate-limit-key",
+			Value:       "ip",
+			Usage:       "how clients are identified; one of ip, header:NAME, cookie:NAME",
+			EnvVar:      "RATE_LIMIT_KEY",
+			Destination: &opts.RateLimitKey,
+		},
+		cli.StringFlag{
+			Name:        "rate-limit-bucket",
+			Usage:       "name of a NATS key value bucket used to share rate limits across gateways",
+			EnvVar:      "RATE_LIMIT_BUCKET",
+			Destination: &opts.RateLimitBucket,
+		},
+		cli.StringFlag{
+			Name:        "hedge",
+			Usage:       "delay before a second request is sent for GET, HEAD and OPTIONS e.g. 50ms, or auto to use the p95 latency",
+			EnvVar:      "HEDGE",
+			Destination: &opts.Hedge,
+		},
+		cli.IntFlag{
+			Name:        "cache",
+			Usage:       "number of GET and HEAD responses to cache in memory, honoring Cache-Control; 0 to disable",
+			EnvVar:      "CACHE",
+			Destination: &opts.Cache,
+		},
+		cli.StringFlag{
+			Name:        "cache-bucket",
+			Usage:       "name of a NATS key value bucket used to share cached responses across gateways",
+			EnvVar:      "CACHE_BUCKET",
+			Destination: &opts.CacheBucket,
+		},
+		cli.StringFlag{
+			Name:        "coalesce",
+			Usage:       "collapse concurrent identical GET and HEAD requests; value is * to compare every forwarded header, or a comma separated list of the headers to compare",
+			EnvVar:      "COALESCE",
+			Destination: &opts.Coalesce,
+		},
+		cli.StringFlag{
+			Name:        "jwks",
+			Usage:       "url or file of the JSON Web Key Set used to verify bearer tokens",
+			EnvVar:      "JWKS",
+			Destination: &opts.JWKS,
+		},
+		cli.StringFlag{
+			Name:        "jwt-issuer",
+			Usage:       "comma separated list of accepted JWT issuers",
+			EnvVar:      "JWT_ISSUER",
+			Destination: &opts.JWTIssuer,
+		},
+		cli.StringFlag{
+			Name:        "jwt-audience",
+			Usage:       "comma separated list of accepted JWT audiences",
+			EnvVar:      "JWT_AUDIENCE",
+			Destination: &opts.JWTAudience,
+		},
+		cli.StringFlag{
+			Name:        "api-keys",
+			Usage:       "file of api keys, one 'key subject' per line",
+			EnvVar:      "API_KEYS",
+			Destination: &opts.APIKeys,
+		},
+		cli.StringFlag{
+			Name:        "api-key-header",
+			Value:       nats_proxy.DefaultAPIKeyHeader,
+			Usage:       "header api keys are read from",
+			EnvVar:      "API_KEY_HEADER",
+			Destination: &opts.APIKeyHeader,
+		},
+		cli.StringFlag{
+			Name:        "basic-auth",
+			Usage:       "file of basic auth users, one 'username:password' per line",
+			EnvVar:      "BASIC_AUTH",
+			Destination: &opts.BasicAuth,
+		},
+		cli.BoolFlag{
+			Name:        "anonymous",
+			Usage:       "admit requests without credentials when authentication is enabled",
+			EnvVar:      "ANONYMOUS",
+			Destination: &opts.Anonymous,
+		},
+		cli.StringFlag{
+			Name:        "policy",
+			Usage:       "json file of authorization rules",
+			EnvVar:      "POLICY",
+			Destination: &opts.Policy,
+		},
+		cli.BoolFlag{
+			Name:        "policy-dry-run",
+			Usage:       "log requests the policy would deny rather than denying them; logged at --log-level info",
+			EnvVar:      "POLICY_DRY_RUN",
+			Destination: &opts.PolicyDryRun,
+		},
+		cli.StringFlag{
+			Name:        "signing-key",
+			Usage:       "key used to sign messages so services can trust them; hmac:ID:BASE64 or ed25519:ID:BASE64_SEED",
+			EnvVar:      "SIGNING_KEY",
+			Destination: &opts.SigningKey,
+		},
+		cli.StringFlag{
+			Name:        "cors-origins",
+			Usage:       "comma separated list of origins allowed cross-origin e.g. https://*.example.com; enables CORS",
+			EnvVar:      "CORS_ORIGINS",
+			Destination: &opts.CORSOrigins,
+		},
+		cli.StringFlag{
+			Name:        "cors-methods",
+			Usage:       "comma separated list of methods allowed cross-origin",
+			EnvVar:      "CORS_METHODS",
+			Destination: &opts.CORSMethods,
+		},
+		cli.StringFlag{
+			Name:        "cors-headers",
+			Usage:       "comma separated list of request headers allowed cross-origin; defaults to those requested",
+			EnvVar:      "CORS_HEADERS",
+			Destination: &opts.CORSHeaders,
+		},
+		cli.StringFlag{
+			Name:        "cors-expose",
+			Usage:       "comma separated list of response headers exposed to scripts",
+			EnvVar:      "CORS_EXPOSE",
+			Destination: &opts.CORSExpose,
+		},
+		cli.BoolFlag{
+			Name:        "cors-credentials",
+			Usage:       "allow cookies and authorization headers cross-origin; not allowed with the * origin",
+			EnvVar:      "CORS_CREDENTIALS",
+			Destination: &opts.CORSCredentials,
+		},
+		cli.DurationFlag{
+			Name:        "cors-max-age",
+			Usage:       "how long browsers may cache preflight results",
+			EnvVar:      "CORS_MAX_AGE",
+			Destination: &opts.CORSMaxAge,
+		},
+		cli.Int64Flag{
+			Name:        "max-body-size",
+			Usage:       "maximum request body size in bytes; defaults to the max_payload of the nats server",
+			EnvVar:      "MAX_BODY_SIZE",
+			Destination: &opts.MaxBodySize,
+		},
+		cli.StringSliceFlag{
+			Name:  "route-max-body-size",
+			Usage: "maximum request body size in bytes for a subject pattern PATTERN=BYTES e.g. api.uploads.>=10485760",
+			Value: &opts.RouteBodySize,
+		},
+		cli.StringFlag{
+			Name:        "compression",
+			Usage:       "comma separated encodings to compress bodies on the nats hop with e.g. zstd,gzip",
+			EnvVar:      "COMPRESSION",
+			Destination: &opts.Compression,
+		},
+		cli.BoolFlag{
+			Name:        "compress-requests",
+			Usage:       "also compress request bodies; every router must support the first encoding",
+			EnvVar:      "COMPRESS_REQUESTS",
+			Destination: &opts.CompressRequest,
+		},
+		cli.BoolFlag{
+			Name:        "compress-responses",
+			Usage:       "compress responses to http clients with brotli or gzip",
+			EnvVar:      "COMPRESS_RESPONSES",
+			Destination: &opts.CompressReplies,
+		},
+		cli.StringFlag{
+			Name:        "codec",
+			Value:       "protobuf",
+			Usage:       "wire format of messages sent to services; protobuf, json or headers",
+			EnvVar:      "CODEC",
+			Destination: &opts.Codec,
+		},
+		cli.StringFlag{
+			Name:        "trusted-proxies",
+			Usage:       "comma separated ip addresses or cidr ranges of proxies whose X-Forwarded headers are trusted",
+			EnvVar:      "TRUSTED_PROXIES",
+			Destination: &opts.TrustedProxies,
+		},
 		cli.StringSliceFlag{
 			Name:  "set",
-			Usage: "set header items KEY=VALUE",
+			Usage: "set request header KEY=VALUE; VALUE is a template, so ${...} is expanded, e.g. ${request_id}, and ${env.NAME} sends the named environment variable of the gateway to services",
 			Value: &opts.Set,
 		},
+		cli.DurationFlag{
+			Name:        "shutdown-timeout",
+			Value:       time.Second * 30,
+			Usage:       "maximum time to wait for in-flight requests and drain the nats connection on shutdown",
+			EnvVar:      "SHUTDOWN_TIMEOUT",
+			Destination: &opts.ShutdownTimeout,
+		},
 	}
 	app.Action = run
-	app.Run(os.Args)
+	check(app.Run(os.Args))
 }
 
 func check(err error) {
@@ -66,33 +377,276 @@ func check(err error) {
 	}
 }
 
-func SetHeaders() nats_proxy.Filter {
-	return func(h nats_proxy.Handler) nats_proxy.Handler {
-		return func(ctx context.Context, subject string, message *nats_proxy.Message) (*nats_proxy.Message, error) {
-			for _, item := range opts.Set {
-				if segments := strings.SplitN(item, "=", 2); len(segments) == 2 {
-					key := segments[0]
-					value := segments[1]
-					message.Header[key] = value
-				}
-			}
-			return h(ctx, subject, message)
+func rewrites() []nats_proxy.HeaderRewrite {
+	var rewrites []nats_proxy.HeaderRewrite
+	if opts.Rewrites != "" {
+		r, err := nats_proxy.ReadRewrites(opts.Rewrites)
+		check(err)
+		rewrites = append(rewrites, r...)
+	}
+	for _, item := range opts.Set {
+		segments := strings.SplitN(item, "=", 2)
+		if len(segments) != 2 {
+			check(fmt.Errorf("invalid header, %v", item))
+		}
+		rewrites = append(rewrites, nats_proxy.HeaderRewrite{
+			Action:    nats_proxy.RewriteSet,
+			Header:    segments[0],
+			Value:     segments[1],
+			Direction: nats_proxy.DirectionRequest,
+		})
+	}
+	return rewrites
+}
+
+func rateKey(s string) (nats_proxy.RateKey, error) {
+	segments := strings.SplitN(s, ":", 2)
+	switch {
+	case s == "ip":
+		return nats_proxy.ByClientIP(), nil
+	case len(segments) == 2 && segments[0] == "header":
+		return nats_proxy.ByHeader(segments[1]), nil
+	case len(segments) == 2 && segments[0] == "cookie":
+		return nats_proxy.ByCookie(segments[1]), nil
+	default:
+		return nil, fmt.Errorf("invalid rate limit key, %v", s)
+	}
+}
+
+func split(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
 		}
 	}
+	return values
+}
+
+func authenticators() []nats_proxy.Authenticator {
+	var authenticators []nats_proxy.Authenticator
+	if opts.JWKS != "" {
+		authenticators = append(authenticators, nats_proxy.JWT(nats_proxy.NewJWKS(opts.JWKS, 0),
+			nats_proxy.JWTIssuer(split(opts.JWTIssuer)...),
+			nats_proxy.JWTAudience(split(opts.JWTAudience)...),
+		))
+	}
+	if opts.APIKeys != "" {
+		store, err := nats_proxy.ReadAPIKeys(opts.APIKeys)
+		check(err)
+		authenticators = append(authenticators, nats_proxy.APIKey(opts.APIKeyHeader, store))
+	}
+	if opts.BasicAuth != "" {
+		users, err := nats_proxy.ReadBasicUsers(opts.BasicAuth)
+		check(err)
+		authenticators = append(authenticators, nats_proxy.Basic("nats-proxy", users))
+	}
+	if opts.Anonymous && len(authenticators) > 0 {
+		authenticators = append(authenticators, nats_proxy.Anonymous())
+	}
+	return authenticators
 }
 
 func run(_ *cli.Context) error {
-	proxy, err := nats_proxy.NewGateway(
+	level, err := nats_proxy.ParseLevel(opts.LogLevel)
+	check(err)
+
+	// shared by the gateway and the kv stores; drained once the gateway has closed
+	connClosed := make(chan struct{})
+	nc, err := nats.Connect(opts.Server, nats.ClosedHandler(func(*nats.Conn) { close(connClosed) }))
+	check(err)
+	defer nc.Close()
+
+	options := []nats_proxy.Option{
+		nats_proxy.WithNats(nc),
+		nats_proxy.WithDrainTimeout(opts.ShutdownTimeout),
+		nats_proxy.WithLogLevel(level),
 		nats_proxy.WithSubject(opts.Subject),
 		nats_proxy.WithHeaders(strings.Split(opts.Headers, ",")...),
 		nats_proxy.WithCookies(strings.Split(opts.Cookies, ",")...),
-		nats_proxy.WithFilters(SetHeaders()),
-	)
+		nats_proxy.WithHealthPath(opts.HealthPath),
+		nats_proxy.WithReadyPath(opts.ReadyPath),
+		nats_proxy.WithPings(strings.Split(opts.Pings, ",")...),
+		nats_proxy.WithServicesPath(opts.ServicesPath),
+	}
+	if rewrites := rewrites(); len(rewrites) > 0 {
+		options = append(options, nats_proxy.WithFilters(nats_proxy.Rewrite(rewrites...)))
+	}
+	if opts.HeaderRules != "" {
+		rules, err := nats_proxy.ReadHeaderRules(opts.HeaderRules)
+		check(err)
+		options = append(options, nats_proxy.WithHeaderRules(rules...))
+	}
+
+	codec, err := nats_proxy.ParseCodec(opts.Codec)
 	check(err)
+	options = append(options, nats_proxy.WithCodec(codec))
+	options = append(options, nats_proxy.WithTrustedProxies(split(opts.TrustedProxies)...))
 
-	fmt.Printf("Listening on port %v\n", opts.Port)
-	err = http.ListenAndServe(fmt.Sprintf(":%v", opts.Port), proxy)
+	if opts.MaxBodySize > 0 {
+		options = append(options, nats_proxy.WithMaxBodySize(opts.MaxBodySize))
+	}
+	for _, item := range opts.RouteBodySize {
+		segments := strings.SplitN(item, "=", 2)
+		if len(segments) != 2 {
+			check(fmt.Errorf("invalid route max body size, %v", item))
+		}
+		n, err := strconv.ParseInt(segments[1], 10, 64)
+		check(err)
+		options = append(options, nats_proxy.WithRouteMaxBodySize(segments[0], n))
+	}
+	if encodings := split(opts.Compression); len(encodings) > 0 {
+		options = append(options, nats_proxy.WithCompression(encodings...))
+		if opts.CompressRequest {
+			options = append(options, nats_proxy.WithRequestCompression())
+		}
+	}
+	if opts.CompressReplies {
+		options = append(options, nats_proxy.WithResponseCompression(nats_proxy.ResponseCompression{}))
+	}
+	if opts.CORSOrigins != "" {
+		options = append(options, nats_proxy.WithCORS(nats_proxy.CORS{
+			AllowedOrigins:   split(opts.CORSOrigins),
+			AllowedMethods:   split(opts.CORSMethods),
+			AllowedHeaders:   split(opts.CORSHeaders),
+			ExposedHeaders:   split(opts.CORSExpose),
+			AllowCredentials: opts.CORSCredentials,
+			MaxAge:           opts.CORSMaxAge,
+		}))
+	}
+	if opts.SigningKey != "" {
+		key, err := nats_proxy.ParseKey(opts.SigningKey)
+		check(err)
+		options = append(options, nats_proxy.WithSigningKey(key))
+	}
+	if opts.Hedge == "auto" {
+		options = append(options, nats_proxy.WithHedging(0))
+	} else if opts.Hedge != "" {
+		delay, err := time.ParseDuration(opts.Hedge)
+		check(err)
+		options = append(options, nats_proxy.WithHedging(delay))
+	}
+	if authenticators := authenticators(); len(authenticators) > 0 {
+		options = append(options, nats_proxy.WithInstrumented("auth", nats_proxy.NewAuth(authenticators...)))
+	}
+	if opts.Policy != "" {
+		rules, err := nats_proxy.ReadRules(opts.Policy)
+		check(err)
+
+		var policyOptions []nats_proxy.PolicyOption
+		if opts.PolicyDryRun {
+			policyOptions = append(policyOptions, nats_proxy.PolicyDryRun())
+		}
+		options = append(options, nats_proxy.WithInstrumented("policy", nats_proxy.NewPolicy(rules, policyOptions...)))
+	}
+	if opts.Cache > 0 || opts.CacheBucket != "" {
+		store := nats_proxy.NewMemoryCacheStore(opts.Cache)
+		if opts.CacheBucket != "" {
+			store = nats_proxy.NewKVCacheStore(nats_proxy.NewKeyValue(nc, opts.CacheBucket))
+		}
+
+		options = append(options, nats_proxy.WithInstrumented("cache", nats_proxy.NewCache(store)))
+	}
+	if opts.Coalesce != "" {
+		var headers []string
+		if opts.Coalesce != "*" {
+			headers = strings.Split(opts.Coalesce, ",")
+		}
+		options = append(options, nats_proxy.WithInstrumented("coalesce",
+			nats_proxy.NewCoalescer(nats_proxy.CoalesceHeaders(headers...)),
+		))
+	}
+	if opts.Breaker {
+		depth := strings.Count(opts.Subject, ".") + 2
+		options = append(options, nats_proxy.WithInstrumented("breaker",
+			nats_proxy.NewCircuitBreaker(nats_proxy.BreakerKey(nats_proxy.SubjectPrefix(depth))),
+		))
+	}
+	if opts.RateLimit != "" {
+		limit, err := nats_proxy.ParseRateLimit(opts.RateLimit)
+		check(err)
+
+		key, err := rateKey(opts.RateLimitKey)
+		check(err)
+
+		store := nats_proxy.NewMemoryRateStore()
+		if opts.RateLimitBucket != "" {
+			store = nats_proxy.NewKVRateStore(nats_proxy.NewKeyValue(nc, opts.RateLimitBucket))
+		}
+
+		options = append(options, nats_proxy.WithInstrumented("ratelimit",
+			nats_proxy.NewRateLimiter(limit, nats_proxy.RateLimitKey(key), nats_proxy.RateLimitStore(store)),
+		))
+	}
+	if opts.RetryAttempts > 1 {
+		options = append(options, nats_proxy.WithInstrumented("retry",
+			nats_proxy.NewRetrier(nats_proxy.RetryAttempts(opts.RetryAttempts)),
+		))
+	}
+
+	proxy, err := nats_proxy.NewGateway(options...)
 	check(err)
 
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%v", opts.Port),
+		Handler: proxy,
+	}
+
+	errs := make(chan error, 2)
+	go func() {
+		fmt.Printf("Listening on port %v\n", opts.Port)
+		errs <- server.ListenAndServe()
+	}()
+
+	var admin *http.Server
+	if opts.AdminAddr != "" {
+		admin = &http.Server{
+			Addr:    opts.AdminAddr,
+			Handler: proxy.Admin(opts.AdminToken),
+		}
+		go func() {
+			fmt.Printf("Admin api listening on %v\n", opts.AdminAddr)
+			errs <- admin.ListenAndServe()
+		}()
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case err := <-errs:
+		proxy.Close()
+		return err
+	case sig := <-stop:
+		fmt.Printf("Received %v, shutting down\n", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.ShutdownTimeout)
+	defer cancel()
+
+	if admin != nil {
+		admin.Close()
+	}
+
+	// the gateway waits for in-flight requests alongside the server so both share the one deadline
+	closed := make(chan error, 1)
+	go func() { closed <- proxy.Close() }()
+	shutdownErr := server.Shutdown(ctx)
+	closeErr := <-closed
+	if err := nc.Drain(); err == nil {
+		select {
+		case <-connClosed:
+		case <-ctx.Done():
+			nc.Close()
+		}
+	}
+
+	if shutdownErr != nil {
+		return cli.NewExitError(fmt.Sprintf("unable to shutdown cleanly, %v", shutdownErr), 2)
+	}
+	if closeErr != nil {
+		return cli.NewExitError(fmt.Sprintf("unable to close gateway, %v", closeErr), 1)
+	}
+
 	return nil
 }
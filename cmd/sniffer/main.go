@@ -13,7 +13,7 @@ import (
 	"os/signal"
 	"time"
 
-	"github.com/nats-io/go-nats"
+	"github.com/nats-io/nats.go"
 	"github.com/savaki/nats-proxy"
 	"github.com/urfave/cli"
 )
@@ -0,0 +1,219 @@
+package nats_proxy
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CoalesceOption configures a Coalescer
+type CoalesceOption func(*Coalescer)
+
+// CoalesceHeaders narrows the request headers that distinguish otherwise identical requests to those named e.g. Accept.
+// By default every header forwarded to the service must match
+func CoalesceHeaders(names ...string) CoalesceOption {
+	return func(c *Coalescer) {
+		for _, name := range names {
+			if name = strings.TrimSpace(name); name != "" {
+				c.headers = append(c.headers, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+}
+
+// CoalesceStats reports the Coalescer's activity
+type CoalesceStats struct {
+	Requests  uint64 `json:"requests"`
+	Coalesced uint64 `json:"coalesced"`
+}
+
+// flight is a request in flight that identical requests wait on
+type flight struct {
+	done chan struct{}
+	out  *Message
+	err  error
+}
+
+// Coalescer collapses concurrent identical GET and HEAD requests, those with the same method, subject, query string,
+// forwarded headers and cookies and identity, into a single request and fans the reply out to every waiter
+type Coalescer struct {
+	requests  uint64 // accessed atomically
+	coalesced uint64 // accessed atomically
+	headers   []string
+	mu        sync.Mutex
+	calls     map[string]*flight
+}
+
+// NewCoalescer returns a new Coalescer; register it with WithInstrumented to report its stats on the admin api
+func NewCoalescer(opts ...CoalesceOption) *Coalescer {
+	c := &Coalescer{
+		calls: map[string]*flight{},
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Stats returns a snapshot of the Coalescer's activity
+func (c *Coalescer) Stats() interface{} {
+	return CoalesceStats{
+		Requests:  atomic.LoadUint64(&c.requests),
+		Coalesced: atomic.LoadUint64(&c.coalesced),
+	}
+}
+
+// key identifies the request as forwarded to the service: its method, subject, query string, headers, cookies and
+// identity.  When CoalesceHeaders is specified, only the named headers are compared
+func (c *Coalescer) key(ctx context.Context, subject string, message *Message) string {
+	req, hasRequest := HTTPRequest(ctx)
+
+	buf := strings.Builder{}
+	buf.WriteString(message.Method)
+	buf.WriteString(" ")
+	buf.WriteString(subject)
+	if hasRequest {
+		buf.WriteString("?")
+		buf.WriteString(req.URL.RawQuery)
+	}
+	if message.Identity != nil {
+		buf.WriteString("\nidentity: ")
+		buf.WriteString(message.Identity.Method)
+		buf.WriteString(":")
+		buf.WriteString(message.Identity.Subject)
+	}
+
+	if len(c.headers) > 0 {
+		for _, name := range c.headers {
+			value := message.Header[name]
+			if hasRequest {
+				value = req.Header.Get(name)
+			}
+			writeKeyValue(&buf, "header ", name, value)
+		}
+	} else {
+		for _, name := range sortedNames(message.Header) {
+			writeKeyValue(&buf, "header ", name, message.Header[name])
+		}
+	}
+
+	names := make([]string, 0, len(message.Cookies))
+	for name := range message.Cookies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeKeyValue(&buf, "cookie ", name, message.Cookies[name].GetValue())
+	}
+
+	return buf.String()
+}
+
+// writeKeyValue appends a quoted name and value to the key so values cannot be crafted to collide with other entries
+func writeKeyValue(buf *strings.Builder, kind, name, value string) {
+	buf.WriteString("\n")
+	buf.WriteString(kind)
+	buf.WriteString(strconv.Quote(name))
+	buf.WriteString(": ")
+	buf.WriteString(strconv.Quote(value))
+}
+
+// sortedNames returns the keys of the map in order
+func sortedNames(m map[string]string) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Filter returns the Filter that coalesces requests
+func (c *Coalescer) Filter() Filter {
+	return func(h Handler) Handler {
+		return func(ctx context.Context, subject string, message *Message) (*Message, error) {
+			if message == nil || (message.Method != http.MethodGet && message.Method != http.MethodHead) {
+				return h(ctx, subject, message)
+			}
+
+			atomic.AddUint64(&c.requests, 1)
+			key := c.key(ctx, subject, message)
+
+			c.mu.Lock()
+			current, ok := c.calls[key]
+			if ok {
+				c.mu.Unlock()
+				atomic.AddUint64(&c.coalesced, 1)
+			} else {
+				current = &flight{done: make(chan struct{})}
+				c.calls[key] = current
+				c.mu.Unlock()
+
+				go c.do(ctx, h, key, current, subject, message)
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-current.done:
+				return share(current.out), current.err
+			}
+		}
+	}
+}
+
+// do performs the request on behalf of all waiters.  The request is detached from the cancellation of the first
+// caller, so a client that disconnects does not fail the others, but remains bounded by its deadline
+func (c *Coalescer) do(ctx context.Context, h Handler, key string, current *flight, subject string, message *Message) {
+	defer func() {
+		c.mu.Lock()
+		delete(c.calls, key)
+		c.mu.Unlock()
+		close(current.done)
+	}()
+
+	var shared context.Context = detached{parent: ctx}
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		shared, cancel = context.WithDeadline(shared, deadline)
+		defer cancel()
+	}
+
+	current.out, current.err = h(shared, subject, message)
+}
+
+// share returns a copy of the reply that each waiter may modify independently
+func share(out *Message) *Message {
+	if out == nil {
+		return nil
+	}
+
+	c := *out
+	c.Header = copyHeader(out.Header)
+	if out.Cookies != nil {
+		c.Cookies = make(map[string]*Cookie, len(out.Cookies))
+		for k, v := range out.Cookies {
+			c.Cookies[k] = v
+		}
+	}
+	return &c
+}
+
+// detached exposes the values of its parent, such as the http request, without its deadline or cancellation
+type detached struct {
+	parent context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
+func (d detached) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
@@ -0,0 +1,100 @@
+package nats_proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoalescer(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	h := func(ctx context.Context, subject string, message *Message) (*Message, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &Message{Status: http.StatusOK, Body: []byte(message.Method)}, nil
+	}
+
+	coalescer := NewCoalescer(CoalesceHeaders("Accept"))
+	gw, err := NewGateway(
+		WithHandler(h),
+		WithInstrumented("coalesce", coalescer),
+	)
+	assert.Nil(t, err)
+	defer gw.Close()
+
+	const n = 10
+	wg := &sync.WaitGroup{}
+	codes := make(chan int, n+2)
+	send := func(method, accept string) {
+		defer wg.Done()
+		req := httptest.NewRequest(method, "http://localhost/foo?a=b", nil)
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		gw.ServeHTTP(w, req)
+		codes <- w.Code
+	}
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go send("GET", "text/html")
+	}
+	wg.Add(2)
+	go send("GET", "application/json") // different header
+	go send("POST", "text/html")       // unsafe method
+
+	// wait for every request to reach the filter before releasing the handler
+	for i := 0; i < 100 && (atomic.LoadUint64(&coalescer.coalesced) < n-1 || atomic.LoadUint64(&coalescer.requests) < n+1); i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	close(release)
+	wg.Wait()
+	close(codes)
+
+	for code := range codes {
+		assert.Equal(t, http.StatusOK, code)
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	assert.Equal(t, CoalesceStats{Requests: n + 1, Coalesced: n - 1}, coalescer.Stats())
+}
+
+func TestCoalescerKey(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://localhost/foo?a=b", nil)
+	req.Header.Set("Accept", "text/html")
+	ctx := context.WithValue(context.Background(), requestKey{}, req)
+
+	message := func(session string, header map[string]string) *Message {
+		return &Message{
+			Method:  "GET",
+			Header:  header,
+			Cookies: map[string]*Cookie{"session": {Value: session}},
+		}
+	}
+
+	c := NewCoalescer()
+	alice := c.key(ctx, "api.foo", message("alice", nil))
+	assert.Equal(t, alice, c.key(ctx, "api.foo", message("alice", nil)))
+	assert.NotEqual(t, alice, c.key(ctx, "api.foo", message("bob", nil)))
+	assert.NotEqual(t, alice, c.key(ctx, "api.foo", message("alice", map[string]string{"Authorization": "Bearer x"})))
+
+	identified := message("alice", nil)
+	identified.Identity = &Identity{Method: "jwt", Subject: "alice"}
+	assert.NotEqual(t, alice, c.key(ctx, "api.foo", identified))
+
+	// narrowed to Accept; other forwarded headers are ignored, cookies are not
+	narrowed := NewCoalescer(CoalesceHeaders("Accept"))
+	assert.Equal(t,
+		narrowed.key(ctx, "api.foo", message("alice", map[string]string{"X-Trace": "1"})),
+		narrowed.key(ctx, "api.foo", message("alice", map[string]string{"X-Trace": "2"})),
+	)
+	assert.NotEqual(t,
+		narrowed.key(ctx, "api.foo", message("alice", nil)),
+		narrowed.key(ctx, "api.foo", message("bob", nil)),
+	)
+}
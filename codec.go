@@ -0,0 +1,303 @@
+package nats_proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ContentTypeProtobuf identifies Messages encoded with protobuf, the default wire format
+	ContentTypeProtobuf = "application/protobuf"
+
+	// ContentTypeJSON identifies Messages encoded as json
+	ContentTypeJSON = "application/json"
+
+	// contentTypeHeader is the nats header that identifies the Codec of a message
+	contentTypeHeader = "Content-Type"
+)
+
+// nats headers used by HeadersCodec
+const (
+	headerPrefix         = "Nats-Proxy-"
+	headerMethod         = headerPrefix + "Method"
+	headerStatus         = headerPrefix + "Status"
+	headerRequestID      = headerPrefix + "Request-Id"
+	headerCookie         = headerPrefix + "Cookie"
+	headerIdentity       = headerPrefix + "Identity"
+	headerSignature      = headerPrefix + "Signature"
+	headerClient         = headerPrefix + "Client"
+	headerEncoding       = headerPrefix + "Encoding"
+	headerAcceptEncoding = headerPrefix + "Accept-Encoding"
+	headerEscaped        = headerPrefix + "Header-" // prefixes http headers that would collide with nats headers
+
+	// requestIDHeader is the http header carried as Nats-Proxy-Request-Id
+	requestIDHeader = "X-Request-Id"
+)
+
+// Codec encodes Messages onto nats messages and back.  Receivers detect the Codec of each nats message, from its
+// Content-Type header or its first byte, so Gateways and Routers using different Codecs interoperate
+type Codec interface {
+	// ContentType identifies the Codec, typically in the Content-Type header of nats messages
+	ContentType() string
+
+	// Encode writes the message onto the data, and optionally the header, of msg
+	Encode(m *Message, msg *nats.Msg) error
+
+	// Decode reads the message from msg
+	Decode(msg *nats.Msg, m *Message) error
+}
+
+var (
+	// ProtobufCodec encodes Messages with protobuf; the default
+	ProtobufCodec Codec = protobufCodec{}
+
+	// JSONCodec encodes Messages as json objects e.g. {"method":"GET","header":{"Accept":"text/plain"}} so services
+	// in other languages and tools such as the nats cli can participate.  Bodies are base64 encoded
+	JSONCodec Codec = jsonCodec{}
+
+	// HeadersCodec carries the method, status, request id and http headers of Messages as nats headers and the body as
+	// the raw payload, so nats tooling and monitoring can see them.  Requires nats-server 2.2 or later
+	HeadersCodec Codec = headersCodec{}
+)
+
+// ParseCodec returns the Codec with the name protobuf, json or headers
+func ParseCodec(name string) (Codec, error) {
+	switch name {
+	case "protobuf", "proto", "":
+		return ProtobufCodec, nil
+	case "json":
+		return JSONCodec, nil
+	case "headers":
+		return HeadersCodec, nil
+	default:
+		return nil, errors.Errorf("unknown codec, %v; expected protobuf, json or headers", name)
+	}
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string {
+	return ContentTypeProtobuf
+}
+
+func (protobufCodec) Encode(m *Message, msg *nats.Msg) error {
+	data, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	msg.Data = data
+	return nil
+}
+
+func (protobufCodec) Decode(msg *nats.Msg, m *Message) error {
+	return proto.Unmarshal(msg.Data, m)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (jsonCodec) Encode(m *Message, msg *nats.Msg) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	msg.Data = data
+	setNatsHeader(msg, contentTypeHeader, ContentTypeJSON)
+	return nil
+}
+
+func (jsonCodec) Decode(msg *nats.Msg, m *Message) error {
+	return json.Unmarshal(msg.Data, m)
+}
+
+type headersCodec struct{}
+
+// ContentType of HeadersCodec is never sent; HeadersCodec messages are identified by their Nats-Proxy- headers
+func (headersCodec) ContentType() string {
+	return "application/vnd.nats-proxy.headers"
+}
+
+// reservedHeader returns true if the http header would collide with headers interpreted by nats itself e.g. the
+// Status header nats uses for no responders, or the Nats- headers used by JetStream
+func reservedHeader(key string) bool {
+	key = http.CanonicalHeaderKey(key)
+	return strings.HasPrefix(key, "Nats-") || key == "Status" || key == "Description"
+}
+
+func (headersCodec) Encode(m *Message, msg *nats.Msg) error {
+	header := nats.Header{}
+	if m.Method != "" {
+		header.Set(headerMethod, m.Method)
+	}
+	if m.Status != 0 {
+		header.Set(headerStatus, strconv.Itoa(int(m.Status)))
+	}
+
+	for key, value := range m.Header {
+		switch {
+		case key == requestIDHeader:
+			header.Set(headerRequestID, value)
+		case reservedHeader(key):
+			header.Set(headerEscaped+key, value)
+		default:
+			header.Set(key, value)
+		}
+	}
+
+	for key, cookie := range m.Cookies {
+		header.Add(headerCookie, httpCookie(key, cookie).String())
+	}
+
+	if m.Identity != nil {
+		data, err := json.Marshal(m.Identity)
+		if err != nil {
+			return err
+		}
+		header.Set(headerIdentity, string(data))
+	}
+	if m.Signature != nil {
+		data, err := json.Marshal(m.Signature)
+		if err != nil {
+			return err
+		}
+		header.Set(headerSignature, string(data))
+	}
+	if m.Client != nil {
+		data, err := json.Marshal(m.Client)
+		if err != nil {
+			return err
+		}
+		header.Set(headerClient, string(data))
+	}
+	if m.Encoding != "" {
+		header.Set(headerEncoding, m.Encoding)
+	}
+	if len(m.AcceptEncoding) > 0 {
+		header.Set(headerAcceptEncoding, strings.Join(m.AcceptEncoding, ","))
+	}
+
+	msg.Header = header
+	msg.Data = m.Body
+	return nil
+}
+
+func (headersCodec) Decode(msg *nats.Msg, m *Message) error {
+	for key, values := range msg.Header {
+		if len(values) == 0 {
+			continue
+		}
+		value := values[0]
+
+		switch {
+		case key == headerMethod:
+			m.Method = value
+		case key == headerStatus:
+			status, err := strconv.Atoi(value)
+			if err != nil {
+				return errors.Wrapf(err, "invalid %v header", headerStatus)
+			}
+			m.Status = int32(status)
+		case key == headerRequestID:
+			setHeader(m, requestIDHeader, value)
+		case key == headerCookie:
+			m.Cookies = readSetCookies(values)
+		case key == headerIdentity:
+			m.Identity = &Identity{}
+			if err := json.Unmarshal([]byte(value), m.Identity); err != nil {
+				return errors.Wrapf(err, "invalid %v header", headerIdentity)
+			}
+		case key == headerSignature:
+			m.Signature = &Signature{}
+			if err := json.Unmarshal([]byte(value), m.Signature); err != nil {
+				return errors.Wrapf(err, "invalid %v header", headerSignature)
+			}
+		case key == headerClient:
+			m.Client = &Client{}
+			if err := json.Unmarshal([]byte(value), m.Client); err != nil {
+				return errors.Wrapf(err, "invalid %v header", headerClient)
+			}
+		case key == headerEncoding:
+			m.Encoding = value
+		case key == headerAcceptEncoding:
+			m.AcceptEncoding = strings.Split(value, ",")
+		case strings.HasPrefix(key, headerEscaped):
+			setHeader(m, key[len(headerEscaped):], value)
+		case reservedHeader(key):
+			// set by nats or JetStream rather than the sender
+		default:
+			setHeader(m, key, value)
+		}
+	}
+
+	if len(msg.Data) > 0 {
+		m.Body = msg.Data
+	}
+	return nil
+}
+
+// setNatsHeader sets a header on the nats message, allocating the header if required
+func setNatsHeader(msg *nats.Msg, key, value string) {
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+	msg.Header.Set(key, value)
+}
+
+// dropContentType removes the Content-Type header when the nats server does not support headers; receivers then
+// detect the Codec from the data
+func dropContentType(nc *nats.Conn, msg *nats.Msg) {
+	if msg.Header == nil || nc.HeadersSupported() {
+		return
+	}
+	msg.Header.Del(contentTypeHeader)
+	if len(msg.Header) == 0 {
+		msg.Header = nil
+	}
+}
+
+// detectCodec returns the Codec a nats message was encoded with.  Messages carrying a Nats-Proxy-Method or
+// Nats-Proxy-Status header use HeadersCodec; otherwise the Content-Type header wins and, failing that, a message
+// starting with { is json since { never begins an encoded protobuf Message
+func detectCodec(msg *nats.Msg) (Codec, error) {
+	if msg.Header.Get(headerMethod) != "" || msg.Header.Get(headerStatus) != "" {
+		return HeadersCodec, nil
+	}
+
+	switch contentType := msg.Header.Get(contentTypeHeader); contentType {
+	case ContentTypeJSON:
+		return JSONCodec, nil
+	case ContentTypeProtobuf:
+		return ProtobufCodec, nil
+	case "":
+		if len(msg.Data) > 0 && msg.Data[0] == '{' {
+			return JSONCodec, nil
+		}
+		return ProtobufCodec, nil
+	default:
+		return nil, errors.Errorf("unsupported content type, %v", contentType)
+	}
+}
+
+// decodeMessage decodes a nats message with whichever Codec it was encoded with; the Codec is returned so replies
+// may be encoded the same way
+func decodeMessage(msg *nats.Msg) (*Message, Codec, error) {
+	codec, err := detectCodec(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m := &Message{}
+	if err := codec.Decode(msg, m); err != nil {
+		return nil, nil, err
+	}
+	return m, codec, nil
+}
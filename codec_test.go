@@ -0,0 +1,151 @@
+package nats_proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodec(t *testing.T) {
+	in := &Message{
+		Method:  "POST",
+		Header:  map[string]string{"Content-Type": "text/plain"},
+		Cookies: map[string]*Cookie{"session; Path=/": {Name: "session", Value: "abc", Path: "/"}},
+		Body:    []byte("hello"),
+	}
+
+	for _, codec := range []Codec{ProtobufCodec, JSONCodec, HeadersCodec} {
+		msg := &nats.Msg{}
+		assert.Nil(t, codec.Encode(in, msg), codec.ContentType())
+
+		detected, err := detectCodec(msg)
+		assert.Nil(t, err)
+		assert.Equal(t, codec, detected)
+
+		out, _, err := decodeMessage(msg)
+		assert.Nil(t, err)
+		assert.Equal(t, in, out)
+	}
+
+	// detected from the data alone when headers are not available
+	for _, codec := range []Codec{ProtobufCodec, JSONCodec} {
+		msg := &nats.Msg{}
+		assert.Nil(t, codec.Encode(in, msg))
+		msg.Header = nil
+
+		detected, err := detectCodec(msg)
+		assert.Nil(t, err)
+		assert.Equal(t, codec, detected)
+	}
+
+	codec, err := ParseCodec("json")
+	assert.Nil(t, err)
+	assert.Equal(t, JSONCodec, codec)
+	_, err = ParseCodec("xml")
+	assert.NotNil(t, err)
+
+	_, err = detectCodec(&nats.Msg{Header: nats.Header{"Content-Type": []string{"application/xml"}}})
+	assert.NotNil(t, err)
+}
+
+func TestJSONRouter(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, req.Method+" "+req.URL.Path)
+	})
+	nc := newTestRouter(t, h, WithSubject("codec")).nc
+
+	gw, err := NewGateway(WithNats(nc), WithSubject("codec"), WithCodec(JSONCodec))
+	assert.Nil(t, err)
+	defer gw.Close()
+
+	w := httptest.NewRecorder()
+	gw.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/foo", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "GET /foo", w.Body.String())
+
+	// as sent by tooling such as: nats req codec.foo '{"method":"DELETE"}'
+	msg, err := nc.Request("codec.foo", []byte(`{"method":"DELETE"}`), time.Second*5)
+	assert.Nil(t, err)
+
+	var out struct {
+		Status int32             `json:"status"`
+		Header map[string]string `json:"header"`
+		Body   []byte            `json:"body"`
+	}
+	assert.Nil(t, json.Unmarshal(msg.Data, &out))
+	assert.Equal(t, int32(http.StatusOK), out.Status)
+	assert.Equal(t, "text/plain", out.Header["Content-Type"])
+	assert.Equal(t, "DELETE /foo", string(out.Body))
+	assert.Equal(t, ContentTypeJSON, msg.Header.Get("Content-Type"))
+}
+
+func TestHeadersCodec(t *testing.T) {
+	in := &Message{
+		Status: http.StatusServiceUnavailable,
+		Header: map[string]string{
+			"Content-Type": "application/json",
+			"X-Request-Id": "abc",
+			"Status":       "busy",
+			"Nats-Msg-Id":  "123",
+		},
+		Identity:       &Identity{Subject: "alice", Method: "jwt", Claims: map[string]string{"role": "admin"}},
+		Signature:      &Signature{KeyId: "a", Algorithm: algorithmHMAC, Expires: 1, Value: []byte{1, 2}},
+		Encoding:       EncodingGzip,
+		AcceptEncoding: []string{EncodingZstd, EncodingGzip},
+		Client:         &Client{RemoteAddr: "203.0.113.1:1234", Host: "example.com", Scheme: "https"},
+	}
+
+	msg := &nats.Msg{}
+	assert.Nil(t, HeadersCodec.Encode(in, msg))
+	assert.Equal(t, "503", msg.Header.Get("Nats-Proxy-Status"))
+	assert.Equal(t, "abc", msg.Header.Get("Nats-Proxy-Request-Id"))
+	assert.Equal(t, "application/json", msg.Header.Get("Content-Type"))
+	assert.Equal(t, "", msg.Header.Get("Status"))
+	assert.Equal(t, "", msg.Header.Get("Nats-Msg-Id"))
+
+	// headers added by nats itself are not mistaken for http headers
+	msg.Header.Set("Nats-Sequence", "42")
+
+	out, codec, err := decodeMessage(msg)
+	assert.Nil(t, err)
+	assert.Equal(t, HeadersCodec, codec)
+	assert.Equal(t, in, out)
+}
+
+func TestHeadersRouter(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		cookie, _ := req.Cookie("session")
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, req.Method+" "+cookie.Value)
+	})
+	nc := newTestRouter(t, h, WithSubject("headers")).nc
+
+	gw, err := NewGateway(WithNats(nc), WithSubject("headers"), WithCookies("session"), WithCodec(HeadersCodec))
+	assert.Nil(t, err)
+	defer gw.Close()
+
+	req := httptest.NewRequest("PUT", "http://localhost/foo", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+	w := httptest.NewRecorder()
+	gw.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "text/plain", w.Header().Get("Content-Type"))
+	assert.Equal(t, "PUT abc", w.Body.String())
+
+	// as sent by tooling such as: nats req -H Nats-Proxy-Method:GET headers.foo ''
+	msg := nats.NewMsg("headers.foo")
+	msg.Header.Set("Nats-Proxy-Method", "GET")
+	msg.Header.Add("Nats-Proxy-Cookie", "session=xyz")
+	reply, err := nc.RequestMsg(msg, time.Second*5)
+	assert.Nil(t, err)
+	assert.Equal(t, "202", reply.Header.Get("Nats-Proxy-Status"))
+	assert.Equal(t, "GET xyz", string(reply.Data))
+}
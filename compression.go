@@ -0,0 +1,148 @@
+package nats_proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+const (
+	// EncodingGzip compresses Message bodies with gzip
+	EncodingGzip = "gzip"
+
+	// EncodingZstd compresses Message bodies with zstd; typically both faster and smaller than gzip
+	EncodingZstd = "zstd"
+
+	// EncodingSnappy compresses Message bodies with snappy; fastest, with the least reduction in size
+	EncodingSnappy = "snappy"
+
+	// DefaultCompressionThreshold specifies the smallest body that will be compressed
+	DefaultCompressionThreshold = 1024
+
+	// maxDecompressedSize bounds the size of a decompressed body to guard against decompression bombs
+	maxDecompressedSize = 64 << 20
+)
+
+// ErrUnsupportedEncoding is returned when a Message body uses an encoding this process does not support
+var ErrUnsupportedEncoding = errors.New("nats_proxy: unsupported encoding")
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedSize))
+)
+
+// compression holds the compression settings of a Gateway or Router
+type compression struct {
+	encodings []string // supported encodings in order of preference; empty disables compression
+	threshold int      // smallest body that will be compressed
+	requests  bool     // true if the Gateway should also compress request bodies
+}
+
+// compress encodes data with the specified encoding
+func compress(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case EncodingGzip:
+		buf := &bytes.Buffer{}
+		w := gzip.NewWriter(buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+
+	case EncodingZstd:
+		return zstdEncoder.EncodeAll(data, nil), nil
+
+	case EncodingSnappy:
+		return snappy.Encode(nil, data), nil
+
+	default:
+		return nil, errors.Wrapf(ErrUnsupportedEncoding, "unable to compress with %v", encoding)
+	}
+}
+
+// decompress decodes data compressed with the specified encoding
+func decompress(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case EncodingGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		decoded, err := ioutil.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(decoded) > maxDecompressedSize {
+			return nil, errors.Errorf("decompressed body exceeds %v bytes", maxDecompressedSize)
+		}
+		return decoded, nil
+
+	case EncodingZstd:
+		return zstdDecoder.DecodeAll(data, nil)
+
+	case EncodingSnappy:
+		n, err := snappy.DecodedLen(data)
+		if err != nil {
+			return nil, err
+		}
+		if n > maxDecompressedSize {
+			return nil, errors.Errorf("decompressed body exceeds %v bytes", maxDecompressedSize)
+		}
+		return snappy.Decode(nil, data)
+
+	default:
+		return nil, errors.Wrapf(ErrUnsupportedEncoding, "unable to decompress %v", encoding)
+	}
+}
+
+// compressBody compresses the body of the message in place with the first of the encodings that the receiver
+// accepts, if the body is at least threshold bytes and compression makes it smaller.  A nil accept means the
+// receiver accepts any encoding
+func compressBody(m *Message, encodings, accept []string, threshold int) error {
+	if m == nil || m.Encoding != "" || len(m.Body) < threshold {
+		return nil
+	}
+
+	for _, encoding := range encodings {
+		if accept != nil && !contains(accept, encoding) {
+			continue
+		}
+
+		compressed, err := compress(encoding, m.Body)
+		if err != nil {
+			return err
+		}
+		if len(compressed) < len(m.Body) {
+			m.Body = compressed
+			m.Encoding = encoding
+		}
+		return nil
+	}
+
+	return nil
+}
+
+// decompressBody restores the body of the message in place
+func decompressBody(m *Message) error {
+	if m == nil || m.Encoding == "" {
+		return nil
+	}
+
+	body, err := decompress(m.Encoding, m.Body)
+	if err != nil {
+		return err
+	}
+	m.Body = body
+	m.Encoding = ""
+	return nil
+}
@@ -0,0 +1,124 @@
+package nats_proxy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompress(t *testing.T) {
+	data := []byte(strings.Repeat("hello world ", 1000))
+
+	for _, encoding := range []string{EncodingGzip, EncodingZstd, EncodingSnappy} {
+		compressed, err := compress(encoding, data)
+		assert.Nil(t, err, encoding)
+		assert.True(t, len(compressed) < len(data), encoding)
+
+		decompressed, err := decompress(encoding, compressed)
+		assert.Nil(t, err, encoding)
+		assert.Equal(t, data, decompressed, encoding)
+	}
+
+	_, err := compress("br", data)
+	assert.Equal(t, ErrUnsupportedEncoding, errors.Cause(err))
+	_, err = decompress("br", data)
+	assert.Equal(t, ErrUnsupportedEncoding, errors.Cause(err))
+}
+
+func TestCompressBody(t *testing.T) {
+	body := []byte(strings.Repeat("a", 2048))
+
+	// below threshold
+	m := &Message{Body: []byte("small")}
+	assert.Nil(t, compressBody(m, []string{EncodingGzip}, nil, DefaultCompressionThreshold))
+	assert.Equal(t, "", m.Encoding)
+
+	// first encoding the receiver accepts
+	m = &Message{Body: body}
+	assert.Nil(t, compressBody(m, []string{EncodingZstd, EncodingGzip}, []string{EncodingGzip}, DefaultCompressionThreshold))
+	assert.Equal(t, EncodingGzip, m.Encoding)
+	assert.Nil(t, decompressBody(m))
+	assert.Equal(t, "", m.Encoding)
+	assert.Equal(t, body, m.Body)
+
+	// no encoding in common
+	m = &Message{Body: body}
+	assert.Nil(t, compressBody(m, []string{EncodingZstd}, []string{EncodingSnappy}, DefaultCompressionThreshold))
+	assert.Equal(t, "", m.Encoding)
+
+	// incompressible bodies are left alone
+	random := make([]byte, 2048)
+	_, err := rand.Read(random)
+	assert.Nil(t, err)
+	m = &Message{Body: random}
+	assert.Nil(t, compressBody(m, []string{EncodingGzip}, nil, DefaultCompressionThreshold))
+	assert.Equal(t, "", m.Encoding)
+	assert.Equal(t, random, m.Body)
+}
+
+func TestCompressedRouter(t *testing.T) {
+	content := strings.Repeat("hello world ", 1000)
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Body != nil {
+			data, _ := ioutil.ReadAll(req.Body)
+			w.Write(data)
+		}
+		w.Write([]byte(content))
+	})
+	nc := newTestRouter(t, h, WithSubject("compressed"), WithCompression(EncodingZstd, EncodingGzip)).nc
+
+	gw, err := NewGateway(WithNats(nc), WithSubject("compressed"),
+		WithCompression(EncodingGzip), WithRequestCompression())
+	assert.Nil(t, err)
+	defer gw.Close()
+
+	request := strings.Repeat("request ", 500)
+	w := httptest.NewRecorder()
+	gw.ServeHTTP(w, httptest.NewRequest("POST", "http://localhost/foo", bytes.NewReader([]byte(request))))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, request+content, w.Body.String())
+
+	// published directly to observe the encoding on the wire
+	data, err := proto.Marshal(&Message{Method: "GET", AcceptEncoding: []string{EncodingGzip}})
+	assert.Nil(t, err)
+	msg, err := nc.Request("compressed.foo", data, time.Second*5)
+	assert.Nil(t, err)
+
+	out := &Message{}
+	assert.Nil(t, proto.Unmarshal(msg.Data, out))
+	assert.Equal(t, EncodingGzip, out.Encoding)
+	assert.True(t, len(out.Body) < len(content))
+	assert.Nil(t, decompressBody(out))
+	assert.Equal(t, content, string(out.Body))
+
+	// requesters that accept nothing receive uncompressed replies
+	data, err = proto.Marshal(&Message{Method: "GET"})
+	assert.Nil(t, err)
+	msg, err = nc.Request("compressed.foo", data, time.Second*5)
+	assert.Nil(t, err)
+
+	out = &Message{}
+	assert.Nil(t, proto.Unmarshal(msg.Data, out))
+	assert.Equal(t, "", out.Encoding)
+	assert.Equal(t, content, string(out.Body))
+
+	// unsupported request encodings are rejected
+	data, err = proto.Marshal(&Message{Method: "POST", Encoding: "br", Body: []byte("x")})
+	assert.Nil(t, err)
+	msg, err = nc.Request("compressed.foo", data, time.Second*5)
+	assert.Nil(t, err)
+
+	out = &Message{}
+	assert.Nil(t, proto.Unmarshal(msg.Data, out))
+	assert.Equal(t, int32(http.StatusBadRequest), out.Status)
+}
@@ -0,0 +1,86 @@
+package nats_proxy
+
+import (
+	"net/http"
+	"time"
+)
+
+// sameSite maps the SameSite attribute of http cookies to and from its name
+var sameSite = map[http.SameSite]string{
+	http.SameSiteLaxMode:    "Lax",
+	http.SameSiteStrictMode: "Strict",
+	http.SameSiteNoneMode:   "None",
+}
+
+// newCookie converts an http cookie into a Cookie, keeping its name and every Set-Cookie attribute
+func newCookie(c *http.Cookie) *Cookie {
+	cookie := &Cookie{
+		Name:     c.Name,
+		Value:    c.Value,
+		Path:     c.Path,
+		Domain:   c.Domain,
+		MaxAge:   int32(c.MaxAge),
+		Secure:   c.Secure,
+		HttpOnly: c.HttpOnly,
+		SameSite: sameSite[c.SameSite],
+	}
+	if !c.Expires.IsZero() {
+		cookie.Expires = c.Expires.Unix()
+	}
+	return cookie
+}
+
+// cookieName returns the name of the Cookie stored under key; the key is the name unless the Cookie carries its own
+func cookieName(key string, c *Cookie) string {
+	if name := c.GetName(); name != "" {
+		return name
+	}
+	return key
+}
+
+// setCookieKey returns the key of a reply cookie.  Cookies with the same name but a different path or domain are
+// distinct, e.g. one clearing a cookie on / while another sets it on /app, so both are part of the key
+func setCookieKey(c *http.Cookie) string {
+	key := c.Name
+	if c.Path != "" {
+		key += "; Path=" + c.Path
+	}
+	if c.Domain != "" {
+		key += "; Domain=" + c.Domain
+	}
+	return key
+}
+
+// httpCookie converts the Cookie stored under key into an http cookie
+func httpCookie(key string, c *Cookie) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     cookieName(key, c),
+		Value:    c.GetValue(),
+		Path:     c.GetPath(),
+		Domain:   c.GetDomain(),
+		MaxAge:   int(c.GetMaxAge()),
+		Secure:   c.GetSecure(),
+		HttpOnly: c.GetHttpOnly(),
+	}
+	if c.GetExpires() != 0 {
+		cookie.Expires = time.Unix(c.GetExpires(), 0).UTC()
+	}
+	for mode, name := range sameSite {
+		if name == c.GetSameSite() {
+			cookie.SameSite = mode
+		}
+	}
+	return cookie
+}
+
+// readSetCookies parses Set-Cookie header values into Cookies keyed by name, path and domain; nil if there are none
+func readSetCookies(values []string) map[string]*Cookie {
+	var cookies map[string]*Cookie
+	for _, c := range (&http.Response{Header: http.Header{"Set-Cookie": values}}).Cookies() {
+		if cookies == nil {
+			cookies = map[string]*Cookie{}
+		}
+		cookies[setCookieKey(c)] = newCookie(c)
+	}
+	return cookies
+}
@@ -0,0 +1,71 @@
+package nats_proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCookie(t *testing.T) {
+	in := &http.Cookie{
+		Name:     "session",
+		Value:    "abc",
+		Path:     "/app",
+		Domain:   "example.com",
+		Expires:  time.Unix(1700000000, 0).UTC(),
+		MaxAge:   3600,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+
+	cookie := newCookie(in)
+	assert.Equal(t, "Strict", cookie.SameSite)
+	assert.Equal(t, int64(1700000000), cookie.Expires)
+
+	out := httpCookie("session", cookie)
+	assert.Equal(t, in.String(), out.String())
+
+	cookies := readSetCookies([]string{in.String(), "theme=dark; Max-Age=0", "session=; Path=/; Max-Age=0"})
+	assert.Len(t, cookies, 3)
+	assert.Equal(t, cookie, cookies["session; Path=/app; Domain=example.com"])
+	assert.Equal(t, int32(-1), cookies["theme"].MaxAge)
+	assert.Equal(t, int32(-1), cookies["session; Path=/"].MaxAge)
+	assert.Nil(t, readSetCookies(nil))
+
+	// cookies from older routers are keyed by name alone
+	assert.Equal(t, "theme", httpCookie("theme", &Cookie{Value: "dark"}).Name)
+}
+
+func TestCookieRouter(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/", HttpOnly: true, Secure: true, SameSite: http.SameSiteLaxMode})
+		http.SetCookie(w, &http.Cookie{Name: "theme", Value: "dark", MaxAge: 60})
+		// same name, different paths
+		http.SetCookie(w, &http.Cookie{Name: "prefs", Value: "", Path: "/", MaxAge: -1})
+		http.SetCookie(w, &http.Cookie{Name: "prefs", Value: "compact", Path: "/app"})
+	})
+	nc := newTestRouter(t, h, WithSubject("cookies")).nc
+
+	for _, codec := range []Codec{ProtobufCodec, JSONCodec, HeadersCodec} {
+		gw, err := NewGateway(WithNats(nc), WithSubject("cookies"), WithCodec(codec))
+		assert.Nil(t, err)
+
+		w := httptest.NewRecorder()
+		gw.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/foo", nil))
+		gw.Close()
+
+		cookies := map[string]*http.Cookie{}
+		for _, c := range w.Result().Cookies() {
+			cookies[c.Name+" "+c.Path] = c
+		}
+		assert.Len(t, cookies, 4, codec.ContentType())
+		assert.Equal(t, "prefs=; Path=/; Max-Age=0", cookies["prefs /"].Raw, codec.ContentType())
+		assert.Equal(t, "prefs=compact; Path=/app", cookies["prefs /app"].Raw, codec.ContentType())
+		assert.Equal(t, "session=abc; Path=/; HttpOnly; Secure; SameSite=Lax", cookies["session /"].Raw, codec.ContentType())
+		assert.Equal(t, 60, cookies["theme "].MaxAge, codec.ContentType())
+	}
+}
@@ -0,0 +1,229 @@
+package nats_proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultCORSMethods specifies the methods allowed cross-origin when CORS.AllowedMethods is empty
+var DefaultCORSMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// CORS configures how the Gateway handles cross-origin requests from browsers
+type CORS struct {
+	// AllowedOrigins lists the origins allowed to call the gateway e.g. https://example.com.  A leading wildcard
+	// matches any subdomain e.g. https://*.example.com, and * alone matches any origin
+	AllowedOrigins []string
+
+	// AllowedMethods lists the methods allowed cross-origin; defaults to DefaultCORSMethods
+	AllowedMethods []string
+
+	// AllowedHeaders lists the request headers allowed cross-origin; if empty, the headers requested by the preflight
+	// are allowed
+	AllowedHeaders []string
+
+	// ExposedHeaders lists the response headers browsers may expose to scripts
+	ExposedHeaders []string
+
+	// AllowCredentials allows cookies and authorization headers to be sent cross-origin; it may not be combined with
+	// the * origin, as any site could then make credentialed requests
+	AllowCredentials bool
+
+	// MaxAge specifies how long browsers may cache the result of a preflight; 0 leaves it to the browser
+	MaxAge time.Duration
+}
+
+// cors is the compiled form of CORS
+type cors struct {
+	origins     []string
+	anyOrigin   bool
+	methods     map[string]struct{}
+	methodList  string
+	headers     map[string]struct{}
+	headerList  string
+	exposed     string
+	credentials bool
+	maxAge      string
+}
+
+// validate returns an error if the configuration is unsafe
+func (c CORS) validate() error {
+	if !c.AllowCredentials {
+		return nil
+	}
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			return errors.New("invalid cors; AllowCredentials may not be combined with the * origin")
+		}
+	}
+	return nil
+}
+
+func newCORS(c CORS) *cors {
+	compiled := &cors{
+		methods:     map[string]struct{}{},
+		headers:     map[string]struct{}{},
+		exposed:     strings.Join(c.ExposedHeaders, ", "),
+		credentials: c.AllowCredentials,
+	}
+
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			compiled.anyOrigin = true
+			continue
+		}
+		compiled.origins = append(compiled.origins, strings.ToLower(origin))
+	}
+
+	allowed := c.AllowedMethods
+	if len(allowed) == 0 {
+		allowed = DefaultCORSMethods
+	}
+	var methods []string
+	for _, method := range allowed {
+		method = strings.ToUpper(method)
+		methods = append(methods, method)
+		compiled.methods[method] = struct{}{}
+	}
+	compiled.methodList = strings.Join(methods, ", ")
+
+	var headers []string
+	for _, header := range c.AllowedHeaders {
+		header = http.CanonicalHeaderKey(header)
+		headers = append(headers, header)
+		compiled.headers[header] = struct{}{}
+	}
+	compiled.headerList = strings.Join(headers, ", ")
+
+	if c.MaxAge > 0 {
+		compiled.maxAge = strconv.Itoa(int(c.MaxAge / time.Second))
+	}
+
+	return compiled
+}
+
+// originAllowed returns true if the origin matches one of the allowed origins
+func (c *cors) originAllowed(origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+
+	origin = strings.ToLower(origin)
+	for _, allowed := range c.origins {
+		if allowed == origin {
+			return true
+		}
+
+		// https://*.example.com matches https://a.example.com and https://a.b.example.com
+		if i := strings.Index(allowed, "*."); i >= 0 {
+			prefix, suffix := allowed[:i], allowed[i+1:]
+			if strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) &&
+				len(origin) > len(prefix)+len(suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isPreflight returns true if the request is a CORS preflight rather than an OPTIONS request meant for a service
+func isPreflight(req *http.Request) bool {
+	return req.Method == http.MethodOptions &&
+		req.Header.Get("Origin") != "" &&
+		req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// decorate adds the CORS response headers for the request, if it is cross-origin and allowed; returns true if allowed
+func (c *cors) decorate(header http.Header, req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if !c.anyOrigin {
+		header.Add("Vary", "Origin")
+	}
+	if origin == "" || !c.originAllowed(origin) {
+		return false
+	}
+
+	if c.anyOrigin {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.credentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if c.exposed != "" {
+		header.Set("Access-Control-Expose-Headers", c.exposed)
+	}
+	return true
+}
+
+// decorateMessage adds the CORS response headers to a reply from a service; Vary is merged with the service's own
+func (c *cors) decorateMessage(out *Message, req *http.Request) {
+	header := http.Header{}
+	c.decorate(header, req)
+
+	for key, values := range header {
+		value := strings.Join(values, ", ")
+		if existing := out.Header[key]; key == "Vary" && existing != "" {
+			value = existing + ", " + value
+		}
+		setHeader(out, key, value)
+	}
+}
+
+// preflight answers a CORS preflight request locally; nothing is sent over nats
+func (c *cors) preflight(w http.ResponseWriter, req *http.Request) {
+	header := w.Header()
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+
+	reject := func() {
+		header.Del("Access-Control-Allow-Origin")
+		header.Del("Access-Control-Allow-Credentials")
+		header.Del("Access-Control-Expose-Headers")
+		w.WriteHeader(http.StatusForbidden)
+	}
+
+	if !c.decorate(header, req) {
+		reject()
+		return
+	}
+
+	method := strings.ToUpper(req.Header.Get("Access-Control-Request-Method"))
+	if _, ok := c.methods[method]; !ok {
+		reject()
+		return
+	}
+
+	requested := req.Header.Get("Access-Control-Request-Headers")
+	if len(c.headers) == 0 {
+		if requested != "" {
+			header.Set("Access-Control-Allow-Headers", requested)
+		}
+	} else {
+		for _, name := range strings.Split(requested, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if _, ok := c.headers[name]; !ok && name != "" {
+				reject()
+				return
+			}
+		}
+		header.Set("Access-Control-Allow-Headers", c.headerList)
+	}
+
+	header.Set("Access-Control-Allow-Methods", c.methodList)
+	if c.maxAge != "" {
+		header.Set("Access-Control-Max-Age", c.maxAge)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,119 @@
+package nats_proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORS(t *testing.T) {
+	calls := 0
+	h := func(ctx context.Context, subject string, message *Message) (*Message, error) {
+		calls++
+		return &Message{Status: http.StatusOK, Header: map[string]string{"Vary": "Accept"}}, nil
+	}
+
+	gw, err := NewGateway(
+		WithHandler(h),
+		WithCORS(CORS{
+			AllowedOrigins:   []string{"https://example.com", "https://*.example.org"},
+			AllowedHeaders:   []string{"Content-Type", "Authorization"},
+			ExposedHeaders:   []string{"X-Request-Id"},
+			AllowCredentials: true,
+			MaxAge:           time.Minute,
+		}),
+	)
+	assert.Nil(t, err)
+	defer gw.Close()
+
+	preflight := func(origin, method, headers string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", "http://localhost/foo", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", method)
+		req.Header.Set("Access-Control-Request-Headers", headers)
+		w := httptest.NewRecorder()
+		gw.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("preflight", func(t *testing.T) {
+		w := preflight("https://a.example.org", "PUT", "content-type, authorization")
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, "https://a.example.org", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+		assert.Equal(t, "Content-Type, Authorization", w.Header().Get("Access-Control-Allow-Headers"))
+		assert.Equal(t, "GET, HEAD, POST, PUT, PATCH, DELETE", w.Header().Get("Access-Control-Allow-Methods"))
+		assert.Equal(t, "60", w.Header().Get("Access-Control-Max-Age"))
+		assert.Equal(t, 0, calls)
+	})
+
+	t.Run("preflight rejected", func(t *testing.T) {
+		for _, w := range []*httptest.ResponseRecorder{
+			preflight("https://evil.com", "GET", ""),
+			preflight("https://example.org", "GET", ""),
+			preflight("https://example.com", "TRACE", ""),
+			preflight("https://example.com", "GET", "X-Other"),
+		} {
+			assert.Equal(t, http.StatusForbidden, w.Code)
+			assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Origin"))
+		}
+		assert.Equal(t, 0, calls)
+	})
+
+	t.Run("request", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://localhost/foo", nil)
+		req.Header.Set("Origin", "https://example.com")
+		w := httptest.NewRecorder()
+		gw.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "https://example.com", w.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "X-Request-Id", w.Header().Get("Access-Control-Expose-Headers"))
+		assert.Equal(t, "Accept, Origin", w.Header().Get("Vary"))
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("options without preflight", func(t *testing.T) {
+		req := httptest.NewRequest("OPTIONS", "http://localhost/foo", nil)
+		w := httptest.NewRecorder()
+		gw.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 2, calls)
+	})
+}
+
+func TestOriginAllowed(t *testing.T) {
+	c := newCORS(CORS{AllowedOrigins: []string{"https://*.example.com"}})
+	assert.True(t, c.originAllowed("https://a.example.com"))
+	assert.True(t, c.originAllowed("https://a.b.example.com"))
+	assert.False(t, c.originAllowed("https://example.com"))
+	assert.False(t, c.originAllowed("https://aexample.com"))
+	assert.False(t, c.originAllowed("http://a.example.com"))
+
+	c = newCORS(CORS{AllowedOrigins: []string{"*"}})
+	assert.True(t, c.originAllowed("https://anything.com"))
+}
+
+func TestCORSCredentialsAnyOrigin(t *testing.T) {
+	_, err := NewGateway(
+		WithNopHandler(),
+		WithCORS(CORS{AllowedOrigins: []string{"https://example.com", "*"}, AllowCredentials: true}),
+	)
+	assert.NotNil(t, err)
+
+	gw, err := NewGateway(WithNopHandler(), WithCORS(CORS{AllowedOrigins: []string{"*"}}))
+	assert.Nil(t, err)
+	defer gw.Close()
+
+	req := httptest.NewRequest("GET", "http://localhost/foo", nil)
+	req.Header.Set("Origin", "https://evil.com")
+	w := httptest.NewRecorder()
+	gw.ServeHTTP(w, req)
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "", w.Header().Get("Access-Control-Allow-Credentials"))
+}
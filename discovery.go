@@ -0,0 +1,212 @@
+package nats_proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// DefaultControlSubject specifies the subject Routers announce themselves on
+	DefaultControlSubject = "_nats_proxy.services"
+
+	// DefaultHeartbeatInterval specifies how frequently a Router announces itself
+	DefaultHeartbeatInterval = time.Second * 5
+
+	// missedHeartbeats specifies how many heartbeats an instance may miss before it is considered gone
+	missedHeartbeats = 3
+)
+
+// Announcement is published by a Router on the control subject to advertise itself
+type Announcement struct {
+	ID       string        `json:"id"`
+	Subject  string        `json:"subject"`
+	Queue    string        `json:"queue"`
+	Version  string        `json:"version,omitempty"`
+	Routes   []string      `json:"routes,omitempty"`
+	Interval time.Duration `json:"interval"`
+	Leaving  bool          `json:"leaving,omitempty"`
+}
+
+// Instance describes a single live Router
+type Instance struct {
+	Announcement
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Service groups the live instances mounted on a single subject
+type Service struct {
+	Subject   string     `json:"subject"`
+	Instances []Instance `json:"instances"`
+}
+
+func newInstanceID() string {
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+func (r *Router) announce(leaving bool) {
+	data, err := json.Marshal(Announcement{
+		ID:       r.id,
+		Subject:  r.subject,
+		Queue:    r.queue,
+		Version:  r.version,
+		Routes:   r.routes,
+		Interval: r.heartbeat,
+		Leaving:  leaving,
+	})
+	if err != nil {
+		log.Printf("Unable to marshal announcement, %v\n", err)
+		return
+	}
+
+	if err := r.nc.Publish(r.controlSubject, data); err != nil {
+		log.Printf("Unable to publish announcement, %v\n", err)
+	}
+}
+
+// heartbeats announces the router periodically until ctx is done, at which point the router announces it is leaving
+func (r *Router) heartbeats(ctx context.Context) {
+	if r.heartbeat <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(r.heartbeat)
+	defer ticker.Stop()
+
+	r.announce(false)
+	for {
+		select {
+		case <-ctx.Done():
+			r.announce(true)
+			return
+		case <-ticker.C:
+			r.announce(false)
+		}
+	}
+}
+
+// Registry tracks the Routers announcing themselves on the control subject
+type Registry struct {
+	nc        *nats.Conn
+	subject   string
+	mu        sync.Mutex
+	instances map[string]Instance
+}
+
+// NewRegistry returns a Registry that listens for announcements on the specified control subject
+func NewRegistry(nc *nats.Conn, subject string) *Registry {
+	if subject == "" {
+		subject = DefaultControlSubject
+	}
+
+	return &Registry{
+		nc:        nc,
+		subject:   subject,
+		instances: map[string]Instance{},
+	}
+}
+
+// Subscribe listens for announcements until ctx is done
+func (r *Registry) Subscribe(ctx context.Context) (<-chan struct{}, error) {
+	sub, err := r.nc.Subscribe(r.subject, r.handler)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		defer sub.Unsubscribe()
+		<-ctx.Done()
+	}()
+
+	return done, nil
+}
+
+func (r *Registry) handler(msg *nats.Msg) {
+	a := Announcement{}
+	if err := json.Unmarshal(msg.Data, &a); err != nil {
+		log.Printf("Unable to unmarshal announcement, %v\n", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.prune(now)
+
+	if a.Leaving {
+		delete(r.instances, a.ID)
+		return
+	}
+
+	r.instances[a.ID] = Instance{
+		Announcement: a,
+		LastSeen:     now,
+	}
+}
+
+// prune drops the instances that have missed their heartbeats; callers must hold mu
+func (r *Registry) prune(now time.Time) {
+	for id, instance := range r.instances {
+		if now.Sub(instance.LastSeen) > instance.Interval*missedHeartbeats {
+			delete(r.instances, id)
+		}
+	}
+}
+
+// Services returns the live services mounted at or below the prefix subject, sorted by subject
+func (r *Registry) Services(prefix string) []Service {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.prune(time.Now())
+
+	bySubject := map[string]*Service{}
+	for _, instance := range r.instances {
+		subject := instance.Subject
+		if prefix != "" && subject != prefix && !strings.HasPrefix(subject, prefix+".") {
+			continue
+		}
+
+		service, ok := bySubject[subject]
+		if !ok {
+			service = &Service{Subject: subject}
+			bySubject[subject] = service
+		}
+		service.Instances = append(service.Instances, instance)
+	}
+
+	services := make([]Service, 0, len(bySubject))
+	for _, service := range bySubject {
+		sort.Slice(service.Instances, func(i, j int) bool {
+			return service.Instances[i].ID < service.Instances[j].ID
+		})
+		services = append(services, *service)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Subject < services[j].Subject
+	})
+
+	return services
+}
+
+// services lists the live services mounted under the gateway's subject root
+func (p *Gateway) services(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	json.NewEncoder(w).Encode(p.registry.Services(p.subject))
+}
@@ -0,0 +1,82 @@
+package nats_proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	nc, err := nats.Connect(nats.DefaultURL)
+	assert.Nil(t, err)
+	defer nc.Close()
+
+	gw, err := NewGateway(
+		WithNats(nc),
+		WithSubject("disco"),
+		WithServicesPath("/_services"),
+	)
+	assert.Nil(t, err)
+	defer gw.Close()
+	assert.Nil(t, nc.Flush()) // the registry is listening before the router announces itself
+
+	r := newTestRouter(t, http.NotFoundHandler(),
+		WithSubject("disco.foo"),
+		WithVersion("1.2.3"),
+		WithRoutes("/foo"),
+		WithHeartbeat(time.Millisecond*100),
+	)
+
+	services := func() []Service {
+		w := httptest.NewRecorder()
+		gw.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/_services", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var services []Service
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&services))
+		return services
+	}
+
+	// When
+	time.Sleep(time.Millisecond * 50)
+
+	// Then
+	found := services()
+	if assert.Len(t, found, 1) {
+		assert.Equal(t, "disco.foo", found[0].Subject)
+		if assert.Len(t, found[0].Instances, 1) {
+			assert.Equal(t, r.id, found[0].Instances[0].ID)
+			assert.Equal(t, "1.2.3", found[0].Instances[0].Version)
+			assert.Equal(t, []string{"/foo"}, found[0].Instances[0].Routes)
+		}
+	}
+
+	// When
+	r.stop()
+	time.Sleep(time.Millisecond * 50)
+
+	// Then
+	assert.Len(t, services(), 0)
+}
+
+func TestRegistryPrune(t *testing.T) {
+	r := NewRegistry(nil, "")
+	r.instances["stale"] = Instance{
+		Announcement: Announcement{ID: "stale", Subject: "api.old", Interval: time.Millisecond},
+		LastSeen:     time.Now().Add(-time.Second),
+	}
+
+	data, err := json.Marshal(Announcement{ID: "live", Subject: "api.new", Interval: time.Second})
+	assert.Nil(t, err)
+	r.handler(&nats.Msg{Data: data})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	assert.Len(t, r.instances, 1)
+	assert.Contains(t, r.instances, "live")
+}
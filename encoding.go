@@ -0,0 +1,215 @@
+package nats_proxy
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// EncodingBrotli compresses responses to http clients with brotli
+const EncodingBrotli = "br"
+
+var (
+	// DefaultResponseEncodings specifies the encodings responses are compressed with, in order of preference, when
+	// ResponseCompression.Encodings is empty
+	DefaultResponseEncodings = []string{EncodingBrotli, EncodingGzip}
+
+	// DefaultCompressibleTypes specifies the content types that are compressed when ResponseCompression.ContentTypes
+	// is empty
+	DefaultCompressibleTypes = []string{
+		"text/*",
+		"application/json",
+		"application/javascript",
+		"application/xml",
+		"application/*+json",
+		"application/*+xml",
+		"image/svg+xml",
+	}
+)
+
+// ResponseCompression configures how the Gateway compresses responses to http clients
+type ResponseCompression struct {
+	// Encodings lists the encodings responses may be compressed with, in order of preference; defaults to
+	// DefaultResponseEncodings.  Supported encodings are br and gzip
+	Encodings []string
+
+	// ContentTypes lists the media types that will be compressed e.g. application/json.  A * matches any subtype
+	// e.g. text/* and a leading * matches a structured syntax suffix e.g. application/*+json.  Defaults to
+	// DefaultCompressibleTypes
+	ContentTypes []string
+
+	// MinSize specifies the smallest body that will be compressed; defaults to DefaultCompressionThreshold
+	MinSize int
+}
+
+// responseEncoder is the compiled form of ResponseCompression
+type responseEncoder struct {
+	encodings    []string
+	contentTypes []string
+	minSize      int
+}
+
+func newResponseEncoder(c ResponseCompression) *responseEncoder {
+	e := &responseEncoder{
+		encodings:    c.Encodings,
+		contentTypes: c.ContentTypes,
+		minSize:      c.MinSize,
+	}
+	if len(e.encodings) == 0 {
+		e.encodings = DefaultResponseEncodings
+	}
+	if len(e.contentTypes) == 0 {
+		e.contentTypes = DefaultCompressibleTypes
+	}
+	if e.minSize <= 0 {
+		e.minSize = DefaultCompressionThreshold
+	}
+	return e
+}
+
+// compressible returns true if responses with the content type should be compressed
+func (e *responseEncoder) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, pattern := range e.contentTypes {
+		pattern = strings.ToLower(pattern)
+		if pattern == mediaType {
+			return true
+		}
+		if i := strings.Index(pattern, "*"); i >= 0 {
+			prefix, suffix := pattern[:i], pattern[i+1:]
+			if strings.HasPrefix(mediaType, prefix) && strings.HasSuffix(mediaType, suffix) &&
+				len(mediaType) > len(prefix)+len(suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseAcceptEncoding returns the quality of each encoding listed in an Accept-Encoding header
+func parseAcceptEncoding(v string) map[string]float64 {
+	accepted := map[string]float64{}
+	for _, item := range strings.Split(v, ",") {
+		segments := strings.Split(item, ";")
+		encoding := strings.ToLower(strings.TrimSpace(segments[0]))
+		if encoding == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range segments[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if value, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = value
+				}
+			}
+		}
+		accepted[encoding] = q
+	}
+	return accepted
+}
+
+// negotiate returns the preferred encoding the client accepts; "" if none
+func (e *responseEncoder) negotiate(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	accepted := parseAcceptEncoding(acceptEncoding)
+	for _, encoding := range e.encodings {
+		q, ok := accepted[encoding]
+		if !ok {
+			q, ok = accepted["*"]
+		}
+		if ok && q > 0 {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// encodeBody compresses the body with an encoding understood by http clients
+func encodeBody(encoding string, data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	switch encoding {
+	case EncodingBrotli:
+		w := brotli.NewWriterLevel(buf, brotli.DefaultCompression)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+
+	default:
+		return compress(encoding, data)
+	}
+}
+
+// encode returns the reply compressed for the client.  Replies the service has already encoded, or asked not to be
+// transformed, are returned untouched.  The reply is copied rather than modified as it may be shared e.g. by the cache
+func (e *responseEncoder) encode(out *Message, req *http.Request) *Message {
+	if out.Header["Content-Encoding"] != "" || !e.compressible(out.Header["Content-Type"]) {
+		return out
+	}
+	if _, ok := parseCacheControl(out.Header["Cache-Control"])["no-transform"]; ok {
+		return out
+	}
+	switch out.Status {
+	case http.StatusNoContent, http.StatusPartialContent, http.StatusNotModified:
+		return out
+	}
+
+	// the response varies by Accept-Encoding whether or not this client receives it compressed
+	encoded := *out
+	encoded.Header = copyHeader(out.Header)
+	addVary(&encoded, "Accept-Encoding")
+
+	if len(out.Body) < e.minSize {
+		return &encoded
+	}
+
+	encoding := e.negotiate(req.Header.Get("Accept-Encoding"))
+	if encoding == "" {
+		return &encoded
+	}
+
+	body, err := encodeBody(encoding, out.Body)
+	if err != nil || len(body) >= len(out.Body) {
+		return &encoded
+	}
+
+	encoded.Body = body
+	encoded.Header["Content-Encoding"] = encoding
+	delete(encoded.Header, "Content-Length")
+	if etag := encoded.Header["Etag"]; strings.HasPrefix(etag, `"`) {
+		encoded.Header["Etag"] = "W/" + etag // the encoded body is no longer byte for byte identical
+	}
+	return &encoded
+}
+
+// addVary adds the header name to the Vary header of the message unless already present
+func addVary(m *Message, name string) {
+	existing := m.Header["Vary"]
+	for _, item := range strings.Split(existing, ",") {
+		if item = strings.TrimSpace(item); item == "*" || strings.EqualFold(item, name) {
+			return
+		}
+	}
+
+	if existing != "" {
+		name = existing + ", " + name
+	}
+	setHeader(m, "Vary", name)
+}
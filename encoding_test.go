@@ -0,0 +1,120 @@
+package nats_proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseCompression(t *testing.T) {
+	content := strings.Repeat(`{"hello":"world"}`, 200)
+
+	var reply *Message
+	h := func(ctx context.Context, subject string, message *Message) (*Message, error) {
+		return reply, nil
+	}
+
+	gw, err := NewGateway(WithHandler(h), WithResponseCompression(ResponseCompression{}))
+	assert.Nil(t, err)
+	defer gw.Close()
+
+	serve := func(acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://localhost/foo", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		w := httptest.NewRecorder()
+		gw.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("brotli", func(t *testing.T) {
+		reply = &Message{
+			Status: http.StatusOK,
+			Header: map[string]string{"Content-Type": "application/json", "Etag": `"abc"`, "Vary": "Accept"},
+			Body:   []byte(content),
+		}
+		w := serve("gzip, deflate, br")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "br", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept, Accept-Encoding", w.Header().Get("Vary"))
+		assert.Equal(t, `W/"abc"`, w.Header().Get("Etag"))
+
+		data, err := ioutil.ReadAll(brotli.NewReader(w.Body))
+		assert.Nil(t, err)
+		assert.Equal(t, content, string(data))
+
+		// the reply itself is left untouched
+		assert.Equal(t, content, string(reply.Body))
+		assert.Equal(t, "Accept", reply.Header["Vary"])
+	})
+
+	t.Run("gzip", func(t *testing.T) {
+		reply = &Message{Header: map[string]string{"Content-Type": "text/html; charset=utf-8"}, Body: []byte(content)}
+		w := serve("gzip;q=0.8, br;q=0")
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+
+		r, err := gzip.NewReader(w.Body)
+		assert.Nil(t, err)
+		data, err := ioutil.ReadAll(r)
+		assert.Nil(t, err)
+		assert.Equal(t, content, string(data))
+	})
+
+	t.Run("not accepted", func(t *testing.T) {
+		reply = &Message{Header: map[string]string{"Content-Type": "application/json"}, Body: []byte(content)}
+		w := serve("identity")
+		assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+		assert.Equal(t, content, w.Body.String())
+	})
+
+	t.Run("skipped", func(t *testing.T) {
+		for name, m := range map[string]*Message{
+			"small":        {Header: map[string]string{"Content-Type": "application/json"}, Body: []byte("{}")},
+			"content type": {Header: map[string]string{"Content-Type": "image/png"}, Body: []byte(content)},
+			"no-transform": {Header: map[string]string{"Content-Type": "application/json", "Cache-Control": "no-transform"}, Body: []byte(content)},
+		} {
+			reply = m
+			w := serve("br, gzip")
+			assert.Equal(t, "", w.Header().Get("Content-Encoding"), name)
+			assert.Equal(t, string(m.Body), w.Body.String(), name)
+		}
+	})
+
+	t.Run("already encoded", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		gz := gzip.NewWriter(buf)
+		gz.Write([]byte(content))
+		gz.Close()
+
+		reply = &Message{
+			Header: map[string]string{"Content-Type": "application/json", "Content-Encoding": "gzip"},
+			Body:   buf.Bytes(),
+		}
+		w := serve("br")
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+		assert.Equal(t, buf.Bytes(), w.Body.Bytes())
+	})
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	e := newResponseEncoder(ResponseCompression{})
+	assert.Equal(t, "br", e.negotiate("gzip, br"))
+	assert.Equal(t, "gzip", e.negotiate("gzip"))
+	assert.Equal(t, "br", e.negotiate("*"))
+	assert.Equal(t, "gzip", e.negotiate("*, br;q=0"))
+	assert.Equal(t, "", e.negotiate("deflate"))
+	assert.Equal(t, "", e.negotiate(""))
+
+	assert.True(t, e.compressible("text/plain; charset=utf-8"))
+	assert.True(t, e.compressible("application/problem+json"))
+	assert.False(t, e.compressible("application/octet-stream"))
+	assert.False(t, e.compressible(""))
+}
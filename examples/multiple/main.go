@@ -6,7 +6,7 @@ import (
 	"net/http"
 	"os"
 
-	"github.com/nats-io/go-nats"
+	"github.com/nats-io/nats.go"
 	"github.com/savaki/nats-proxy"
 )
 
@@ -3,42 +3,209 @@ package nats_proxy
 import (
 	"context"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
 
-	"github.com/gogo/protobuf/proto"
-	"github.com/nats-io/go-nats"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
 )
 
 // Gateway is our http -> nats gateway
 type Gateway struct {
-	headers map[string]struct{}
-	cookies map[string]struct{}
-	subject string
-	h       Handler
-	onError func(err error, w http.ResponseWriter, req *http.Request)
+	inFlight   int64 // number of requests currently being served; accessed atomically
+	timeout    int64 // maximum duration of a request in nanoseconds; accessed atomically
+	closed     int32 // set to 1 once Close has been called; accessed atomically
+	logLevel   int32 // current Level; accessed atomically
+	nc         *nats.Conn
+	ownsConn   bool          // true if nc was created by the Gateway and should be closed by it
+	connClosed chan struct{} // closed once nc has been closed; only set when ownsConn
+	drain      time.Duration // max time Close waits for in-flight requests
+	headers    headerFilter  // headers and cookies passed across nats
+	subject    string
+	pings      []string                    // downstream subjects pinged by the readiness check
+	codec      Codec                       // encodes the readiness pings
+	local      map[string]http.HandlerFunc // paths served by the gateway itself rather than routed over nats
+	registry   *Registry                   // tracks live services; only set when a services path is configured
+	cancel     context.CancelFunc          // stops background subscriptions owned by the gateway
+	filters    []string                    // names of the configured filters
+	metrics    map[string]Instrumented     // stateful filters reported on the admin api
+	errors     *errorLog                   // most recent errors
+	cors       *cors                       // answers preflights and decorates replies; nil if CORS is not configured
+	encoder    *responseEncoder            // compresses replies to clients; nil if response compression is disabled
+	maxBody    int64                       // maximum request body size; 0 leaves only the nats max_payload
+	bodyLimits []bodyLimit                 // maximum request body sizes by subject pattern
+	proxies    []*net.IPNet                // trusted proxies whose X-Forwarded headers are honored
+	write      Writer                      // passes replies through the ResponseFilters; nil if none are configured
+	h          Handler
+	onError    func(err error, w http.ResponseWriter, req *http.Request)
 }
 
 // ServeHTTP implements the http.Handler contract.  Wraps messages into a *Message and performs a nats request
 func (p *Gateway) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	atomic.AddInt64(&p.inFlight, 1)
+	defer atomic.AddInt64(&p.inFlight, -1)
+
+	if fn, ok := p.local[req.URL.Path]; ok {
+		p.reply(w, req, recordMessage(fn, req))
+		return
+	}
+
+	if p.cors != nil && isPreflight(req) {
+		writeMessage(w, p.filter(req, recordMessage(p.cors.preflight, req)))
+		return
+	}
+
+	if atomic.LoadInt32(&p.closed) == 1 {
+		p.reply(w, req, recordMessage(func(w http.ResponseWriter, _ *http.Request) {
+			http.Error(w, "gateway is shutting down", http.StatusServiceUnavailable)
+		}, req))
+		return
+	}
+
+	started := time.Now()
 	subject := makeSubject(req, p.subject)
 
-	in, err := messageFromRequest(req, p.headers, p.cookies)
+	if err := limitBody(req, p.maxBodySize(subject)); err != nil {
+		p.fail(err, w, req, subject)
+		return
+	}
+
+	in, err := messageFromRequest(req, p.headers, subject)
 	if err != nil {
-		p.onError(err, w, req)
+		p.fail(err, w, req, subject)
 		return
 	}
+	in.Client = p.client(req)
+
+	ctx := context.WithValue(req.Context(), requestKey{}, req)
+	ctx = context.WithValue(ctx, gatewayKey{}, p)
+	if timeout := p.Timeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
-	out, err := p.h.Apply(req.Context(), subject, in)
+	out, err := p.h.Apply(ctx, subject, in)
 	if err != nil {
-		p.onError(err, w, req)
+		p.fail(err, w, req, subject)
 		return
 	}
+	if out == nil {
+		out = &Message{Status: http.StatusBadGateway} // a Filter or Handler returned neither a reply nor an error
+	}
+
+	p.reply(w, req, out)
+	p.logf(LevelDebug, "%v %v -> %v %v %v", req.Method, req.URL.Path, subject, out.Status, time.Since(started))
+}
 
+// reply passes the reply through the ResponseFilters, then applies the header rules, CORS and response compression
+// and writes it to the client
+func (p *Gateway) reply(w http.ResponseWriter, req *http.Request, out *Message) {
+	out = p.filter(req, out)
+	out = p.headers.response(out, makeSubject(req, p.subject))
+	if p.cors != nil {
+		p.cors.decorateMessage(out, req)
+	}
+	if p.encoder != nil {
+		out = p.encoder.encode(out, req)
+	}
 	writeMessage(w, out)
 }
 
+// filter returns the reply as written by the ResponseFilters, including any headers they set and changes they make
+// to the body
+func (p *Gateway) filter(req *http.Request, out *Message) *Message {
+	if p.write == nil {
+		return out
+	}
+	return recordMessage(func(w http.ResponseWriter, req *http.Request) {
+		p.write(w, req, out)
+	}, req)
+}
+
+// recordMessage returns the response written by fn as a *Message
+func recordMessage(fn http.HandlerFunc, req *http.Request) *Message {
+	w := &messageWriter{header: http.Header{}}
+	fn(w, req)
+	return w.message()
+}
+
+// ErrDrainTimeout is returned by Close when in-flight requests outlast the drain timeout
+var ErrDrainTimeout = errors.New("nats_proxy: timed out waiting for in-flight requests")
+
+type requestKey struct{}
+
+// HTTPRequest returns the *http.Request being served by the Gateway; available to Filters via their context
+func HTTPRequest(ctx context.Context) (*http.Request, bool) {
+	req, ok := ctx.Value(requestKey{}).(*http.Request)
+	return req, ok
+}
+
+// fail records the error and replies with the response of the configured error handler
+func (p *Gateway) fail(err error, w http.ResponseWriter, req *http.Request, subject string) {
+	p.errors.add(ErrorEntry{
+		Time:    time.Now(),
+		Method:  req.Method,
+		Path:    req.URL.Path,
+		Subject: subject,
+		Error:   err.Error(),
+	})
+	p.logf(LevelError, "%v %v -> %v failed, %v", req.Method, req.URL.Path, subject, err)
+
+	p.reply(w, req, recordMessage(func(w http.ResponseWriter, req *http.Request) {
+		if errors.Cause(err) == ErrBodyTooLarge {
+			tooLarge(w)
+			return
+		}
+		p.onError(err, w, req)
+	}, req))
+}
+
+// Timeout returns the maximum amount of time a request may take; 0 indicates no limit
+func (p *Gateway) Timeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&p.timeout))
+}
+
+// SetTimeout changes the maximum amount of time a request may take; safe to call while the Gateway is serving requests
+func (p *Gateway) SetTimeout(d time.Duration) {
+	atomic.StoreInt64(&p.timeout, int64(d))
+	p.logf(LevelInfo, "timeout set to %v", d)
+}
+
+// Close stops the Gateway from accepting new requests and waits up to the drain timeout for in-flight requests to
+// complete, returning ErrDrainTimeout if they do not.  If the nats connection was created by the Gateway, it is
+// drained and closed; connections provided via WithNats are left for the caller to manage
+func (p *Gateway) Close() error {
+	if !atomic.CompareAndSwapInt32(&p.closed, 0, 1) {
+		return nil
+	}
+	defer p.cancel()
+
+	var err error
+	deadline := time.Now().Add(p.drain)
+	for atomic.LoadInt64(&p.inFlight) > 0 {
+		if !time.Now().Before(deadline) {
+			err = ErrDrainTimeout
+			break
+		}
+		time.Sleep(drainInterval)
+	}
+
+	if !p.ownsConn {
+		return err
+	}
+
+	if drainErr := p.nc.Drain(); drainErr != nil {
+		return drainErr
+	}
+	<-p.connClosed
+
+	return err
+}
+
 // NewGateway returns a new http to nats gateway with the options provided
 func NewGateway(opts ...Option) (*Gateway, error) {
 	c, err := readConfig(opts...)
@@ -46,19 +213,85 @@ func NewGateway(opts ...Option) (*Gateway, error) {
 		return nil, err
 	}
 
+	trustedProxies, err := parseTrustedProxies(c.trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range c.headerRules {
+		if err := rule.validate(); err != nil {
+			return nil, errors.Wrap(err, "invalid header rules")
+		}
+	}
+	if c.cors != nil {
+		if err := c.cors.validate(); err != nil {
+			return nil, err
+		}
+	}
+
 	h := c.handler
 	if h == nil {
-		h = request(c.nc, c.timeout)
+		h = request(c.nc, c.codec, c.compression)
+	}
+	if c.signingKey != nil {
+		h = signMessages(h, *c.signingKey, c.signatureTTL)
+	}
+	if c.hedge {
+		h = hedge(h, c.hedgeDelay)
 	}
 
 	h = Chain(h, c.filters...)
 
-	return &Gateway{
-		headers: c.headers,
-		subject: c.subject,
-		h:       h,
-		onError: c.onError,
-	}, nil
+	ctx, cancel := context.WithCancel(context.Background())
+
+	gw := &Gateway{
+		timeout:    int64(c.timeout),
+		drain:      c.drainTimeout,
+		logLevel:   int32(c.logLevel),
+		nc:         c.nc,
+		ownsConn:   c.ownsConn,
+		connClosed: c.connClosed,
+		headers:    headerFilter(c.headerRules),
+		subject:    c.subject,
+		pings:      c.pings,
+		codec:      c.codec,
+		local:      map[string]http.HandlerFunc{},
+		h:          h,
+		onError:    c.onError,
+		cancel:     cancel,
+		filters:    c.filterNames,
+		metrics:    c.instrumented,
+		errors:     newErrorLog(DefaultRecentErrors),
+		maxBody:    c.maxBody,
+		bodyLimits: c.bodyLimits,
+		proxies:    trustedProxies,
+	}
+
+	if c.cors != nil {
+		gw.cors = newCORS(*c.cors)
+	}
+	if c.encoding != nil {
+		gw.encoder = newResponseEncoder(*c.encoding)
+	}
+	if len(c.writers) > 0 {
+		gw.write = ChainWriter(writeMessageTo, c.writers...)
+	}
+
+	if c.healthPath != "" {
+		gw.local[c.healthPath] = gw.healthz
+	}
+	if c.readyPath != "" {
+		gw.local[c.readyPath] = gw.readyz
+	}
+	if c.servicesPath != "" {
+		gw.registry = NewRegistry(c.nc, c.controlSubject)
+		if _, err := gw.registry.Subscribe(ctx); err != nil {
+			cancel()
+			return nil, err
+		}
+		gw.local[c.servicesPath] = gw.services
+	}
+
+	return gw, nil
 }
 
 func makeSubject(req *http.Request, prefix string) string {
@@ -75,29 +308,14 @@ func makeSubject(req *http.Request, prefix string) string {
 	return subject
 }
 
-func messageFromRequest(req *http.Request, headers, cookies map[string]struct{}) (*Message, error) {
+func messageFromRequest(req *http.Request, headers headerFilter, subject string) (*Message, error) {
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
 		return nil, err
 	}
 	defer req.Body.Close()
 
-	h := map[string]string{}
-	for key := range headers {
-		if value := req.Header.Get(key); value != "" {
-			h[key] = value
-		}
-	}
-
-	c := map[string]*Cookie{}
-	for name := range cookies {
-		if cookie, err := req.Cookie(name); err == nil {
-			c[name] = &Cookie{
-				Value: cookie.Value,
-				Path:  cookie.Path,
-			}
-		}
-	}
+	h, c := headers.request(req, subject)
 
 	return &Message{
 		Method:  req.Method,
@@ -107,10 +325,18 @@ func messageFromRequest(req *http.Request, headers, cookies map[string]struct{})
 	}, nil
 }
 
+// writeMessageTo is the Writer at the end of the ResponseFilters
+func writeMessageTo(w http.ResponseWriter, _ *http.Request, out *Message) {
+	writeMessage(w, out)
+}
+
 func writeMessage(w http.ResponseWriter, out *Message) {
 	for k, v := range out.Header {
 		w.Header().Set(k, v)
 	}
+	for key, cookie := range out.Cookies {
+		http.SetCookie(w, httpCookie(key, cookie))
+	}
 	status := out.Status
 	if status == 0 {
 		status = http.StatusOK
@@ -122,23 +348,39 @@ func writeMessage(w http.ResponseWriter, out *Message) {
 	}
 }
 
-func request(nc *nats.Conn, timeout time.Duration) Handler {
+// request publishes the message to nats and waits for the reply; the request is bounded by the deadline of ctx
+func request(nc *nats.Conn, codec Codec, c compression) Handler {
 	return func(ctx context.Context, subject string, m *Message) (*Message, error) {
-		data, err := proto.Marshal(m)
-		if err != nil {
-			return nil, err
+		if len(c.encodings) > 0 {
+			compressed := *m // leave the caller's message intact for retries and hedged requests
+			compressed.AcceptEncoding = c.encodings
+			if c.requests {
+				if err := compressBody(&compressed, c.encodings[:1], nil, c.threshold); err != nil {
+					return nil, err
+				}
+			}
+			m = &compressed
 		}
 
-		if timeout > 0 {
-			ctx, _ = context.WithTimeout(ctx, timeout)
+		msg := &nats.Msg{Subject: subject}
+		if err := codec.Encode(m, msg); err != nil {
+			return nil, err
 		}
-		out, err := nc.RequestWithContext(ctx, subject, data)
+		dropContentType(nc, msg)
+		if err := checkPayload(messageSize(msg), nc.MaxPayload()); err != nil {
+			return nil, err
+		}
+
+		out, err := nc.RequestMsgWithContext(ctx, msg)
 		if err != nil {
 			return nil, err
 		}
 
-		outMessage := &Message{}
-		if err := proto.Unmarshal(out.Data, outMessage); err != nil {
+		outMessage, _, err := decodeMessage(out)
+		if err != nil {
+			return nil, err
+		}
+		if err := decompressBody(outMessage); err != nil {
 			return nil, err
 		}
 
@@ -3,7 +3,10 @@ package nats_proxy
 import (
 	"context"
 	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -30,3 +33,45 @@ func TestFilter(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, status, out.Status)
 }
+
+func TestGatewayClose(t *testing.T) {
+	gw, err := NewGateway(WithNopHandler())
+	assert.Nil(t, err)
+	assert.True(t, gw.ownsConn)
+
+	// When
+	assert.Nil(t, gw.Close())
+
+	// Then
+	assert.True(t, gw.nc.IsClosed())
+
+	w := httptest.NewRecorder()
+	gw.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestGatewayCloseTimeout(t *testing.T) {
+	release := make(chan struct{})
+	h := func(ctx context.Context, subject string, message *Message) (*Message, error) {
+		<-release
+		return &Message{Status: http.StatusOK}, nil
+	}
+
+	gw, err := NewGateway(WithHandler(h), WithDrainTimeout(time.Millisecond*100))
+	assert.Nil(t, err)
+	defer close(release)
+
+	go gw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost/slow", nil))
+	for atomic.LoadInt64(&gw.inFlight) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// When
+	started := time.Now()
+	err = gw.Close()
+
+	// Then
+	assert.Equal(t, ErrDrainTimeout, err)
+	assert.True(t, time.Since(started) < time.Second)
+	assert.True(t, gw.nc.IsClosed())
+}
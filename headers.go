@@ -0,0 +1,228 @@
+package nats_proxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultHeaders lists the request headers passed across nats in addition to those specified by WithHeaders
+var DefaultHeaders = []string{
+	"Content-Disposition",
+	"Content-Encoding",
+	"Content-Length",
+	"Content-Range",
+	"Content-Type",
+	"Set-Cookie",
+}
+
+// Direction selects whether a HeaderRule applies to requests, responses or, if empty, both
+type Direction string
+
+const (
+	// DirectionRequest applies a HeaderRule to requests sent from http clients to services
+	DirectionRequest Direction = "request"
+
+	// DirectionResponse applies a HeaderRule to replies sent from services to http clients
+	DirectionResponse Direction = "response"
+)
+
+// HeaderRule allows or denies the http headers and cookies whose names match its patterns.  Patterns are exact names
+// or globs e.g. X-Custom-*; header patterns are case insensitive.  A rule may be limited to one Direction and to
+// subjects matching Subjects, using NATS wildcard semantics, to override the rules of other routes.
+//
+// Deny always wins over Allow.  Requests only carry headers and cookies that some rule allows, while replies carry
+// everything no rule denies.  Reply cookies are sent as Set-Cookie headers, so denying Set-Cookie denies them all
+type HeaderRule struct {
+	Effect    Effect    `json:"effect"`
+	Headers   []string  `json:"headers,omitempty"`
+	Cookies   []string  `json:"cookies,omitempty"`
+	Direction Direction `json:"direction,omitempty"`
+	Subjects  []string  `json:"subjects,omitempty"`
+}
+
+func (r HeaderRule) applies(direction Direction, subject string) bool {
+	if r.Direction != "" && r.Direction != direction {
+		return false
+	}
+	if len(r.Subjects) == 0 {
+		return true
+	}
+	for _, pattern := range r.Subjects {
+		if subjectMatches(pattern, subject) {
+			return true
+		}
+	}
+	return false
+}
+
+// validate returns an error if the rule is incomplete or any of its patterns are malformed
+func (r HeaderRule) validate() error {
+	if r.Effect != Allow && r.Effect != Deny {
+		return errors.New("effect must be allow or deny")
+	}
+	if r.Direction != "" && r.Direction != DirectionRequest && r.Direction != DirectionResponse {
+		return errors.New("direction must be request or response")
+	}
+	if len(r.Headers) == 0 && len(r.Cookies) == 0 {
+		return errors.New("at least one header or cookie required")
+	}
+	for _, patterns := range [][]string{r.Headers, r.Cookies} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return errors.Errorf("invalid pattern, %v", pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// nameMatches returns true if the name matches any of the patterns
+func nameMatches(patterns []string, name string, caseInsensitive bool) bool {
+	if caseInsensitive {
+		name = strings.ToLower(name)
+	}
+	for _, pattern := range patterns {
+		if caseInsensitive {
+			pattern = strings.ToLower(pattern)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// headerFilter applies HeaderRules to Messages crossing the Gateway
+type headerFilter []HeaderRule
+
+func (f headerFilter) allowed(direction Direction, subject string, cookie bool, name string) bool {
+	allowed := direction == DirectionResponse
+	for _, rule := range f {
+		if !rule.applies(direction, subject) {
+			continue
+		}
+
+		patterns, caseInsensitive := rule.Headers, true
+		if cookie {
+			patterns, caseInsensitive = rule.Cookies, false
+		}
+		if !nameMatches(patterns, name, caseInsensitive) {
+			continue
+		}
+
+		if rule.Effect == Deny {
+			return false
+		}
+		allowed = true
+	}
+	return allowed
+}
+
+// filtersResponses returns true if any rule may remove headers or cookies from replies
+func (f headerFilter) filtersResponses() bool {
+	for _, rule := range f {
+		if rule.Effect == Deny && rule.Direction != DirectionRequest {
+			return true
+		}
+	}
+	return false
+}
+
+// request returns the headers and cookies of the request that may be passed to services on the subject
+func (f headerFilter) request(req *http.Request, subject string) (map[string]string, map[string]*Cookie) {
+	headers := map[string]string{}
+	for key := range req.Header {
+		if f.allowed(DirectionRequest, subject, false, key) {
+			if value := req.Header.Get(key); value != "" {
+				headers[key] = value
+			}
+		}
+	}
+
+	cookies := map[string]*Cookie{}
+	for _, cookie := range req.Cookies() {
+		if f.allowed(DirectionRequest, subject, true, cookie.Name) {
+			cookies[cookie.Name] = &Cookie{
+				Value: cookie.Value,
+				Path:  cookie.Path,
+			}
+		}
+	}
+
+	return headers, cookies
+}
+
+// response returns the reply without the headers and cookies denied to clients of the subject.  The reply is copied
+// rather than modified as it may be shared e.g. by the cache
+func (f headerFilter) response(out *Message, subject string) *Message {
+	if !f.filtersResponses() {
+		return out
+	}
+
+	filtered := *out
+	filtered.Header = map[string]string{}
+	for key, value := range out.Header {
+		if f.allowed(DirectionResponse, subject, false, key) {
+			filtered.Header[key] = value
+		}
+	}
+
+	filtered.Cookies = nil
+	setCookie := f.allowed(DirectionResponse, subject, false, "Set-Cookie")
+	for key, cookie := range out.Cookies {
+		if setCookie && f.allowed(DirectionResponse, subject, true, cookieName(key, cookie)) {
+			if filtered.Cookies == nil {
+				filtered.Cookies = map[string]*Cookie{}
+			}
+			filtered.Cookies[key] = cookie
+		}
+	}
+
+	return &filtered
+}
+
+// patterns returns the header, or cookie, patterns allowed on requests to every subject
+func (f headerFilter) patterns(cookie bool) []string {
+	var patterns []string
+	for _, rule := range f {
+		if rule.Effect != Allow || rule.Direction == DirectionResponse || len(rule.Subjects) > 0 {
+			continue
+		}
+		if cookie {
+			patterns = append(patterns, rule.Cookies...)
+		} else {
+			patterns = append(patterns, rule.Headers...)
+		}
+	}
+	return patterns
+}
+
+// ParseHeaderRules parses a json array of HeaderRules
+func ParseHeaderRules(data []byte) ([]HeaderRule, error) {
+	var rules []HeaderRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, errors.Wrap(err, "invalid header rules")
+	}
+
+	for i, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return nil, errors.Errorf("invalid header rules, rule %v: %v", i, err)
+		}
+	}
+
+	return rules, nil
+}
+
+// ReadHeaderRules reads a json array of HeaderRules from a file
+func ReadHeaderRules(filename string) ([]HeaderRule, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseHeaderRules(data)
+}
@@ -0,0 +1,98 @@
+package nats_proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderFilter(t *testing.T) {
+	f := headerFilter{
+		{Effect: Allow, Headers: DefaultHeaders, Direction: DirectionRequest},
+		{Effect: Allow, Headers: []string{"x-custom-*", "Authorization"}, Cookies: []string{"session_*"}, Direction: DirectionRequest},
+		{Effect: Deny, Headers: []string{"Authorization"}, Subjects: []string{"api.public.>"}},
+		{Effect: Deny, Headers: []string{"Server", "X-Internal-*"}, Cookies: []string{"debug"}, Direction: DirectionResponse},
+	}
+
+	req := httptest.NewRequest("GET", "http://localhost/foo", nil)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Custom-Tenant", "a")
+	req.Header.Set("X-Other", "b")
+	req.Header.Set("Authorization", "Bearer token")
+	req.AddCookie(&http.Cookie{Name: "session_id", Value: "abc"})
+	req.AddCookie(&http.Cookie{Name: "tracking", Value: "xyz"})
+
+	headers, cookies := f.request(req, "api.users")
+	assert.Equal(t, map[string]string{
+		"Content-Type":    "application/json",
+		"X-Custom-Tenant": "a",
+		"Authorization":   "Bearer token",
+	}, headers)
+	assert.Equal(t, map[string]*Cookie{"session_id": {Value: "abc"}}, cookies)
+
+	// deny wins for the routes it applies to
+	headers, _ = f.request(req, "api.public.docs")
+	assert.NotContains(t, headers, "Authorization")
+	assert.Contains(t, headers, "X-Custom-Tenant")
+
+	out := &Message{
+		Header: map[string]string{
+			"Content-Type":      "text/plain",
+			"Server":            "internal/1.0",
+			"X-Internal-Region": "us-east-1",
+		},
+		Cookies: map[string]*Cookie{"debug": {Value: "1"}, "session_id": {Value: "abc"}},
+	}
+	filtered := f.response(out, "api.users")
+	assert.Equal(t, map[string]string{"Content-Type": "text/plain"}, filtered.Header)
+	assert.Equal(t, map[string]*Cookie{"session_id": {Value: "abc"}}, filtered.Cookies)
+	assert.Len(t, out.Header, 3)
+	assert.Len(t, out.Cookies, 2)
+
+	// cookies are written as Set-Cookie headers
+	noCookies := append(f, HeaderRule{Effect: Deny, Headers: []string{"set-cookie"}, Subjects: []string{"api.public.>"}})
+	assert.Nil(t, noCookies.response(out, "api.public.docs").Cookies)
+	assert.Len(t, noCookies.response(out, "api.users").Cookies, 1)
+
+	assert.Equal(t, append(append([]string{}, DefaultHeaders...), "x-custom-*", "Authorization"), f.patterns(false))
+	assert.Equal(t, []string{"session_*"}, f.patterns(true))
+}
+
+func TestParseHeaderRules(t *testing.T) {
+	rules, err := ParseHeaderRules([]byte(`[
+		{"effect": "allow", "headers": ["X-Custom-*"], "direction": "request"},
+		{"effect": "deny", "headers": ["Authorization"], "subjects": ["api.public.>"]}
+	]`))
+	assert.Nil(t, err)
+	assert.Len(t, rules, 2)
+	assert.Equal(t, DirectionRequest, rules[0].Direction)
+
+	for _, data := range []string{
+		`[{"effect": "maybe", "headers": ["a"]}]`,
+		`[{"effect": "allow", "headers": ["a"], "direction": "sideways"}]`,
+		`[{"effect": "allow"}]`,
+		`[{"effect": "allow", "headers": ["[a"]}]`,
+		`{}`,
+	} {
+		_, err := ParseHeaderRules([]byte(data))
+		assert.NotNil(t, err, data)
+	}
+}
+
+func TestGatewayHeaderPatterns(t *testing.T) {
+	for label, opt := range map[string]Option{
+		"headers": WithHeaders("X-[a"),
+		"cookies": WithCookies("session_["),
+		"rules":   WithHeaderRules(HeaderRule{Effect: Allow, Headers: []string{"X-Custom-*"}, Direction: "sideways"}),
+	} {
+		t.Run(label, func(t *testing.T) {
+			_, err := NewGateway(WithHandler(func(ctx context.Context, subject string, message *Message) (*Message, error) {
+				return message, nil
+			}), opt)
+			assert.NotNil(t, err)
+		})
+	}
+}
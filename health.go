@@ -0,0 +1,154 @@
+package nats_proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// DefaultHealthPath is the conventional path for the Gateway's liveness check; see WithHealthPath
+	DefaultHealthPath = "/healthz"
+
+	// DefaultReadyPath is the conventional path for the Gateway's readiness check; see WithReadyPath
+	DefaultReadyPath = "/readyz"
+
+	// DefaultPingTimeout specifies how long the readiness check will wait for a downstream subject to reply
+	DefaultPingTimeout = time.Second * 2
+)
+
+// NatsStatus describes the state of the nats connection
+type NatsStatus struct {
+	Status       string `json:"status"`
+	ConnectedURL string `json:"connected_url,omitempty"`
+	Reconnecting bool   `json:"reconnecting"`
+	Reconnects   uint64 `json:"reconnects"`
+	RTT          string `json:"rtt,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// Health is the body returned by the health and readiness endpoints
+type Health struct {
+	OK     bool              `json:"ok"`
+	Nats   *NatsStatus       `json:"nats,omitempty"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+func statusName(status nats.Status) string {
+	switch status {
+	case nats.CONNECTED:
+		return "connected"
+	case nats.CONNECTING:
+		return "connecting"
+	case nats.RECONNECTING:
+		return "reconnecting"
+	case nats.DISCONNECTED:
+		return "disconnected"
+	case nats.CLOSED:
+		return "closed"
+	case nats.DRAINING_SUBS, nats.DRAINING_PUBS:
+		return "draining"
+	default:
+		return "unknown"
+	}
+}
+
+func natsStatus(nc *nats.Conn) *NatsStatus {
+	status := &NatsStatus{
+		Status:       statusName(nc.Status()),
+		ConnectedURL: nc.ConnectedUrl(),
+		Reconnecting: nc.IsReconnecting(),
+		Reconnects:   nc.Stats().Reconnects,
+	}
+
+	if nc.IsConnected() {
+		if rtt, err := nc.RTT(); err != nil {
+			status.Error = err.Error()
+		} else {
+			status.RTT = rtt.String()
+		}
+	}
+
+	return status
+}
+
+// ping sends a HEAD request, encoded with the gateway's codec, to the subject; any reply other than a 5xx counts
+// as success
+func (p *Gateway) ping(ctx context.Context, subject string) error {
+	ctx, cancel := context.WithTimeout(ctx, DefaultPingTimeout)
+	defer cancel()
+
+	out, err := request(p.nc, p.codec, compression{})(ctx, subject, &Message{Method: http.MethodHead})
+	if err != nil {
+		return err
+	}
+	if out.Status >= http.StatusInternalServerError {
+		return fmt.Errorf("replied with status %v", out.Status)
+	}
+
+	return nil
+}
+
+func writeHealth(w http.ResponseWriter, health Health) {
+	status := http.StatusOK
+	if !health.OK {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(health)
+}
+
+// healthz reports whether the gateway is alive; it fails only once the gateway has been closed
+func (p *Gateway) healthz(w http.ResponseWriter, _ *http.Request) {
+	writeHealth(w, Health{
+		OK: atomic.LoadInt32(&p.closed) == 0,
+	})
+}
+
+// readyz reports whether the gateway is able to serve traffic: the nats connection must be established and each of
+// the configured downstream subjects must reply to a ping
+func (p *Gateway) readyz(w http.ResponseWriter, req *http.Request) {
+	status := natsStatus(p.nc)
+	health := Health{
+		OK:   atomic.LoadInt32(&p.closed) == 0 && p.nc.IsConnected(),
+		Nats: status,
+	}
+
+	if len(p.pings) > 0 {
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+
+		health.Checks = map[string]string{}
+		for _, subject := range p.pings {
+			wg.Add(1)
+			go func(subject string) {
+				defer wg.Done()
+
+				result := "ok"
+				err := p.ping(req.Context(), subject)
+				if err != nil {
+					result = err.Error()
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				health.Checks[subject] = result
+				if err != nil {
+					health.OK = false
+				}
+			}(subject)
+		}
+		wg.Wait()
+	}
+
+	writeHealth(w, health)
+}
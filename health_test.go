@@ -0,0 +1,99 @@
+package nats_proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth(t *testing.T) {
+	gw, err := NewGateway(
+		WithHandler(func(ctx context.Context, subject string, message *Message) (*Message, error) {
+			return nil, errors.New("health checks should not be routed over nats")
+		}),
+		WithHealthPath(DefaultHealthPath),
+		WithReadyPath(DefaultReadyPath),
+	)
+	assert.Nil(t, err)
+	defer gw.Close()
+
+	for _, path := range []string{DefaultHealthPath, DefaultReadyPath} {
+		w := httptest.NewRecorder()
+		gw.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost"+path, nil))
+		assert.Equal(t, http.StatusOK, w.Code, path)
+
+		health := Health{}
+		assert.Nil(t, json.NewDecoder(w.Body).Decode(&health))
+		assert.True(t, health.OK, path)
+	}
+}
+
+func TestHealthDisabledByDefault(t *testing.T) {
+	var subjects []string
+	gw, err := NewGateway(
+		WithHandler(func(ctx context.Context, subject string, message *Message) (*Message, error) {
+			subjects = append(subjects, subject)
+			return &Message{Status: http.StatusOK}, nil
+		}),
+	)
+	assert.Nil(t, err)
+	defer gw.Close()
+
+	for _, path := range []string{DefaultHealthPath, DefaultReadyPath} {
+		gw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost"+path, nil))
+	}
+	assert.Equal(t, []string{"api.healthz", "api.readyz"}, subjects)
+}
+
+func TestReadyPingFailure(t *testing.T) {
+	gw, err := NewGateway(
+		WithNopHandler(),
+		WithReadyPath(DefaultReadyPath),
+		WithPings("health.no-such-service"),
+	)
+	assert.Nil(t, err)
+	defer gw.Close()
+
+	w := httptest.NewRecorder()
+	gw.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost"+DefaultReadyPath, nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	health := Health{}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&health))
+	assert.False(t, health.OK)
+	assert.Equal(t, "connected", health.Nats.Status)
+	assert.NotEqual(t, "ok", health.Checks["health.no-such-service"])
+}
+
+func TestReadyPingStatus(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, http.MethodHead, req.Method)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	nc := newTestRouter(t, h, WithSubject("ready.down")).nc
+	newTestRouter(t, http.NotFoundHandler(), WithSubject("ready.up"))
+
+	gw, err := NewGateway(
+		WithNats(nc),
+		WithCodec(JSONCodec),
+		WithReadyPath(DefaultReadyPath),
+		WithPings("ready.up", "ready.down"),
+	)
+	assert.Nil(t, err)
+	defer gw.Close()
+
+	w := httptest.NewRecorder()
+	gw.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost"+DefaultReadyPath, nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	health := Health{}
+	assert.Nil(t, json.NewDecoder(w.Body).Decode(&health))
+	assert.False(t, health.OK)
+	assert.Equal(t, "ok", health.Checks["ready.up"]) // a 404 still shows the service is up
+	assert.NotEqual(t, "ok", health.Checks["ready.down"])
+}
@@ -0,0 +1,145 @@
+package nats_proxy
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// hedgeSamples specifies how many recent latencies are retained to derive the hedging delay
+	hedgeSamples = 512
+
+	// hedgeMinSamples specifies how many latencies must be observed before an adaptive hedging delay is used
+	hedgeMinSamples = 20
+
+	// hedgePercentile specifies the percentile of recent latencies used as the adaptive hedging delay
+	hedgePercentile = 0.95
+)
+
+// latencies tracks recent request latencies in a fixed size ring
+type latencies struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	count   int
+	stale   bool
+	cached  time.Duration
+}
+
+func newLatencies(size int) *latencies {
+	return &latencies{
+		samples: make([]time.Duration, size),
+	}
+}
+
+func (l *latencies) observe(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.samples[l.next] = d
+	l.next = (l.next + 1) % len(l.samples)
+	if l.count < len(l.samples) {
+		l.count++
+	}
+	l.stale = true
+}
+
+// percentile returns the requested percentile of the retained latencies or 0 if too few have been observed
+func (l *latencies) percentile(p float64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.count < hedgeMinSamples {
+		return 0
+	}
+
+	if l.stale {
+		sorted := make([]time.Duration, l.count)
+		copy(sorted, l.samples[:l.count])
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		l.cached = sorted[int(float64(len(sorted)-1)*p)]
+		l.stale = false
+	}
+
+	return l.cached
+}
+
+func isSafe(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
+
+// hedge sends a second, identical request for safe methods if no reply has arrived within the hedging delay and
+// returns whichever reply arrives first, cancelling the other.  A delay of 0 derives the delay from the 95th percentile
+// of recent latencies
+func hedge(h Handler, delay time.Duration) Handler {
+	recent := newLatencies(hedgeSamples)
+
+	return func(ctx context.Context, subject string, message *Message) (*Message, error) {
+		if message == nil || !isSafe(message.Method) {
+			return h(ctx, subject, message)
+		}
+
+		d := delay
+		if d <= 0 {
+			d = recent.percentile(hedgePercentile)
+		}
+
+		started := time.Now()
+		if d <= 0 {
+			out, err := h(ctx, subject, message)
+			if err == nil {
+				recent.observe(time.Since(started))
+			}
+			return out, err
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		type result struct {
+			out *Message
+			err error
+		}
+		results := make(chan result, 2)
+		send := func() {
+			out, err := h(ctx, subject, message)
+			results <- result{out: out, err: err}
+		}
+
+		go send()
+		pending := 1
+
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+
+		hedged := false
+		for {
+			select {
+			case <-timer.C:
+				if !hedged {
+					hedged = true
+					pending++
+					go send()
+				}
+
+			case r := <-results:
+				pending--
+				if r.err == nil {
+					recent.observe(time.Since(started))
+					return r.out, nil
+				}
+				if pending == 0 {
+					return nil, r.err
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package nats_proxy
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHedge(t *testing.T) {
+	var calls int32
+	h := func(ctx context.Context, subject string, message *Message) (*Message, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-ctx.Done() // the slow instance
+			return nil, ctx.Err()
+		}
+		return &Message{Status: http.StatusOK}, nil
+	}
+
+	t.Run("safe methods are hedged", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		started := time.Now()
+
+		out, err := hedge(h, time.Millisecond*20).Apply(context.Background(), "api", &Message{Method: http.MethodGet})
+		assert.Nil(t, err)
+		assert.Equal(t, int32(http.StatusOK), out.Status)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+		assert.True(t, time.Since(started) < time.Second)
+	})
+
+	t.Run("unsafe methods are not hedged", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer cancel()
+
+		_, err := hedge(h, time.Millisecond*20).Apply(ctx, "api", &Message{Method: http.MethodPost})
+		assert.Equal(t, context.DeadlineExceeded, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+}
+
+func TestLatencies(t *testing.T) {
+	l := newLatencies(100)
+	assert.Equal(t, time.Duration(0), l.percentile(0.95))
+
+	for i := 1; i <= 100; i++ {
+		l.observe(time.Duration(i) * time.Millisecond)
+	}
+	assert.Equal(t, time.Millisecond*95, l.percentile(0.95))
+}
@@ -0,0 +1,483 @@
+package nats_proxy
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultJWKSRefresh specifies how long a key set is cached before it is fetched again
+	DefaultJWKSRefresh = time.Hour
+
+	// jwksMinRefresh bounds how often a key set is fetched again to find a key id it does not contain
+	jwksMinRefresh = time.Second * 30
+
+	// jwksMinBackoff is the wait before fetching again after a failed fetch; it doubles with each consecutive failure
+	// up to jwksMinRefresh
+	jwksMinBackoff = time.Second
+)
+
+// KeySet resolves the key used to verify a JWT from the key id in its header
+type KeySet interface {
+	Key(ctx context.Context, kid string) (interface{}, error)
+}
+
+// jwk is a single JSON Web Key as described by RFC 7517
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	data, err := decodeSegment(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// key returns the public key, or secret for kty oct, described by the jwk
+func (k jwk) key() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.Errorf("unsupported curve, %v", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, errors.Errorf("unsupported curve, %v", k.Crv)
+		}
+		x, err := decodeSegment(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+
+	case "oct":
+		return decodeSegment(k.K)
+
+	default:
+		return nil, errors.Errorf("unsupported key type, %v", k.Kty)
+	}
+}
+
+// parseJWKS parses a JSON Web Key Set, skipping keys that are not used for signatures or are not supported
+func parseJWKS(data []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, errors.Wrap(err, "invalid key set")
+	}
+
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.key()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+type jwks struct {
+	location string
+	refresh  time.Duration
+	client   *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]interface{}
+	fetched   time.Time  // time of the last successful fetch
+	attempted time.Time  // time of the last fetch, successful or not
+	failures  int        // consecutive failed fetches
+	err       error      // error of the last fetch; nil if it succeeded
+	loading   *jwksFetch // fetch in progress, shared by every request waiting on it; nil if none
+}
+
+// jwksFetch is a single fetch of the key set
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// NewJWKS returns a KeySet loaded from a JSON Web Key Set at the specified location, either an http(s) url or a local
+// file.  Keys are cached for refresh, or DefaultJWKSRefresh if 0, and fetched again early when a token names a key id
+// the set does not contain e.g. after the issuer rotates its keys.  Concurrent requests share a single fetch, and
+// while the issuer is unreachable the last keys fetched are served and fetches are retried with backoff
+func NewJWKS(location string, refresh time.Duration) KeySet {
+	if refresh <= 0 {
+		refresh = DefaultJWKSRefresh
+	}
+
+	return &jwks{
+		location: location,
+		refresh:  refresh,
+		client:   &http.Client{Timeout: time.Second * 10},
+	}
+}
+
+func (j *jwks) fetch(ctx context.Context) (map[string]interface{}, error) {
+	if !strings.HasPrefix(j.location, "http://") && !strings.HasPrefix(j.location, "https://") {
+		data, err := ioutil.ReadFile(strings.TrimPrefix(j.location, "file://"))
+		if err != nil {
+			return nil, err
+		}
+		return parseJWKS(data)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, j.location, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := j.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unable to fetch key set, %v: %v", j.location, resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return parseJWKS(data)
+}
+
+// backoff returns how long to wait after the last failed fetch before fetching again
+func (j *jwks) backoff() time.Duration {
+	d := jwksMinBackoff
+	for i := 1; i < j.failures && d < jwksMinRefresh; i++ {
+		d *= 2
+	}
+	if d > jwksMinRefresh {
+		d = jwksMinRefresh
+	}
+	return d
+}
+
+// due returns true if the key set should be fetched again; must be called with mu held
+func (j *jwks) due(now time.Time, found bool) bool {
+	if j.failures > 0 && now.Sub(j.attempted) < j.backoff() {
+		return false
+	}
+	if now.Sub(j.fetched) > j.refresh {
+		return true
+	}
+	return !found && now.Sub(j.attempted) >= jwksMinRefresh
+}
+
+// load fetches the key set, keeping the last good keys if the fetch fails
+func (j *jwks) load(f *jwksFetch) {
+	// not bound to any one request as the result is shared; the client timeout bounds it
+	keys, err := j.fetch(context.Background())
+
+	j.mu.Lock()
+	j.attempted = time.Now()
+	j.err = err
+	if err != nil {
+		j.failures++
+	} else {
+		j.keys = keys
+		j.fetched = j.attempted
+		j.failures = 0
+	}
+	j.loading = nil
+	j.mu.Unlock()
+
+	f.err = err
+	close(f.done)
+}
+
+func (j *jwks) Key(ctx context.Context, kid string) (interface{}, error) {
+	j.mu.Lock()
+	key, ok := j.keys[kid]
+	if !j.due(time.Now(), ok) {
+		err := j.err
+		j.mu.Unlock()
+		if ok {
+			return key, nil
+		}
+		if err != nil {
+			return nil, err // still backing off from the failed fetch
+		}
+		return nil, errors.Errorf("unknown key id, %v", kid)
+	}
+
+	f := j.loading
+	if f == nil {
+		f = &jwksFetch{done: make(chan struct{})}
+		j.loading = f
+		go j.load(f)
+	}
+	j.mu.Unlock()
+
+	if ok {
+		return key, nil // the refresh completes in the background; the key is still good until then
+	}
+
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	j.mu.Lock()
+	key, ok = j.keys[kid]
+	j.mu.Unlock()
+	if !ok {
+		return nil, errors.Errorf("unknown key id, %v", kid)
+	}
+	return key, nil
+}
+
+// verifySignature verifies the signature of a JWT signed with the specified alg
+func verifySignature(alg string, key interface{}, signed, signature []byte) error {
+	var h crypto.Hash
+	switch alg[len(alg)-3:] {
+	case "256":
+		h = crypto.SHA256
+	case "384":
+		h = crypto.SHA384
+	case "512":
+		h = crypto.SHA512
+	}
+
+	digest := func() []byte {
+		var d hash.Hash
+		switch h {
+		case crypto.SHA384:
+			d = sha512.New384()
+		case crypto.SHA512:
+			d = sha512.New()
+		default:
+			d = sha256.New()
+		}
+		d.Write(signed)
+		return d.Sum(nil)
+	}
+
+	switch {
+	case alg == "EdDSA":
+		if pub, ok := key.(ed25519.PublicKey); ok && ed25519.Verify(pub, signed, signature) {
+			return nil
+		}
+
+	case h != 0 && strings.HasPrefix(alg, "HS"):
+		if secret, ok := key.([]byte); ok {
+			var mac hash.Hash
+			switch h {
+			case crypto.SHA384:
+				mac = hmac.New(sha512.New384, secret)
+			case crypto.SHA512:
+				mac = hmac.New(sha512.New, secret)
+			default:
+				mac = hmac.New(sha256.New, secret)
+			}
+			mac.Write(signed)
+			if hmac.Equal(mac.Sum(nil), signature) {
+				return nil
+			}
+		}
+
+	case h != 0 && strings.HasPrefix(alg, "RS"):
+		if pub, ok := key.(*rsa.PublicKey); ok && rsa.VerifyPKCS1v15(pub, h, digest(), signature) == nil {
+			return nil
+		}
+
+	case h != 0 && strings.HasPrefix(alg, "PS"):
+		if pub, ok := key.(*rsa.PublicKey); ok && rsa.VerifyPSS(pub, h, digest(), signature, nil) == nil {
+			return nil
+		}
+
+	case h != 0 && strings.HasPrefix(alg, "ES"):
+		if pub, ok := key.(*ecdsa.PublicKey); ok {
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			if len(signature) == 2*size {
+				r := new(big.Int).SetBytes(signature[:size])
+				s := new(big.Int).SetBytes(signature[size:])
+				if ecdsa.Verify(pub, digest(), r, s) {
+					return nil
+				}
+			}
+		}
+
+	default:
+		return errors.Errorf("unsupported alg, %v", alg)
+	}
+
+	return errors.New("invalid signature")
+}
+
+// audience accepts the aud claim as either a single string or an array of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+// registered holds the registered claims checked when a JWT is verified
+type registered struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt *float64 `json:"exp"`
+	NotBefore *float64 `json:"nbf"`
+}
+
+// verifyJWT verifies the signature and time based claims of a compact serialized JWT, returning its claims
+func verifyJWT(ctx context.Context, token string, keys KeySet, now time.Time, leeway time.Duration) (registered, map[string]interface{}, error) {
+	var claims registered
+
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		return claims, nil, errors.New("malformed token")
+	}
+
+	data, err := decodeSegment(segments[0])
+	if err != nil {
+		return claims, nil, errors.Wrap(err, "malformed header")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return claims, nil, errors.Wrap(err, "malformed header")
+	}
+	if len(header.Alg) < 5 || header.Alg == "none" {
+		return claims, nil, errors.Errorf("unsupported alg, %v", header.Alg)
+	}
+
+	signature, err := decodeSegment(segments[2])
+	if err != nil {
+		return claims, nil, errors.Wrap(err, "malformed signature")
+	}
+
+	key, err := keys.Key(ctx, header.Kid)
+	if err != nil {
+		return claims, nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(segments[0]+"."+segments[1]), signature); err != nil {
+		return claims, nil, err
+	}
+
+	if data, err = decodeSegment(segments[1]); err != nil {
+		return claims, nil, errors.Wrap(err, "malformed claims")
+	}
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return claims, nil, errors.Wrap(err, "malformed claims")
+	}
+	all := map[string]interface{}{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return claims, nil, errors.Wrap(err, "malformed claims")
+	}
+
+	if claims.ExpiresAt != nil && now.Add(-leeway).After(unixTime(*claims.ExpiresAt)) {
+		return claims, nil, errors.New("token expired")
+	}
+	if claims.NotBefore != nil && now.Add(leeway).Before(unixTime(*claims.NotBefore)) {
+		return claims, nil, errors.New("token not yet valid")
+	}
+
+	return claims, all, nil
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+// claimValue renders a claim as a string; strings are used as is, anything else as json
+func claimValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
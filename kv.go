@@ -0,0 +1,133 @@
+package nats_proxy
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+var (
+	// ErrKeyNotFound is returned by KeyValue.Get when the key does not exist or has been deleted
+	ErrKeyNotFound = errors.New("nats_proxy: key not found")
+
+	// ErrWrongRevision is returned by KeyValue.Create and KeyValue.Update when the key has been modified since the
+	// revision provided
+	ErrWrongRevision = errors.New("nats_proxy: wrong last revision")
+)
+
+// KeyValue is a minimal key value store with optimistic concurrency; used to share state, such as rate limits and
+// cached responses, across gateway replicas
+type KeyValue interface {
+	// Get returns the current value of the key and its revision
+	Get(ctx context.Context, key string) ([]byte, uint64, error)
+
+	// Put sets the value of the key unconditionally
+	Put(ctx context.Context, key string, value []byte) (uint64, error)
+
+	// Create sets the value of the key only if it does not already exist
+	Create(ctx context.Context, key string, value []byte) (uint64, error)
+
+	// Update sets the value of the key only if its current revision matches the one provided
+	Update(ctx context.Context, key string, value []byte, revision uint64) (uint64, error)
+}
+
+// natsKV implements KeyValue over a NATS JetStream key value bucket
+type natsKV struct {
+	nc     *nats.Conn
+	bucket string
+
+	mu    sync.Mutex
+	store nats.KeyValue // bound on first use, so the bucket may be created after the gateway starts
+}
+
+// NewKeyValue returns a KeyValue backed by the JetStream key value bucket with the specified name.  The bucket must
+// already exist e.g. nats kv add <bucket> --ttl 1h; its TTL bounds how long entries are retained.  Keys must be valid
+// NATS subject tokens.  Requests are bounded by the JetStream default wait; ctx is checked before each request
+func NewKeyValue(nc *nats.Conn, bucket string) KeyValue {
+	return &natsKV{
+		nc:     nc,
+		bucket: bucket,
+	}
+}
+
+// bind returns the bucket, looking it up on first use
+func (kv *natsKV) bind(ctx context.Context) (nats.KeyValue, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if kv.store != nil {
+		return kv.store, nil
+	}
+
+	js, err := kv.nc.JetStream()
+	if err != nil {
+		return nil, err
+	}
+	store, err := js.KeyValue(kv.bucket)
+	if err != nil {
+		return nil, err
+	}
+	kv.store = store
+	return store, nil
+}
+
+// kvError translates the errors of the nats key value api into those of KeyValue
+func kvError(err error) error {
+	switch {
+	case errors.Is(err, nats.ErrKeyNotFound):
+		return ErrKeyNotFound
+	case errors.Is(err, nats.ErrKeyExists):
+		return ErrWrongRevision
+	default:
+		return err
+	}
+}
+
+func (kv *natsKV) Get(ctx context.Context, key string) ([]byte, uint64, error) {
+	store, err := kv.bind(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entry, err := store.Get(key)
+	if err != nil {
+		return nil, 0, kvError(err)
+	}
+	return entry.Value(), entry.Revision(), nil
+}
+
+func (kv *natsKV) Put(ctx context.Context, key string, value []byte) (uint64, error) {
+	store, err := kv.bind(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	revision, err := store.Put(key, value)
+	return revision, kvError(err)
+}
+
+func (kv *natsKV) Create(ctx context.Context, key string, value []byte) (uint64, error) {
+	store, err := kv.bind(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	revision, err := store.Create(key, value)
+	return revision, kvError(err)
+}
+
+func (kv *natsKV) Update(ctx context.Context, key string, value []byte, revision uint64) (uint64, error) {
+	store, err := kv.bind(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	revision, err = store.Update(key, value, revision)
+	return revision, kvError(err)
+}
@@ -0,0 +1,106 @@
+package nats_proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+// ErrBodyTooLarge is returned when a request body exceeds the limit for its route or a message exceeds the
+// max_payload of the nats server; the Gateway replies 413 Request Entity Too Large
+var ErrBodyTooLarge = errors.New("nats_proxy: request body too large")
+
+// bodyLimit is the maximum body size for subjects matching a pattern
+type bodyLimit struct {
+	pattern string
+	limit   int64
+}
+
+// maxBodySize returns the maximum body size for requests on the subject; 0 if unlimited.  Limits are capped by the
+// max_payload of the nats server as larger messages could never be published
+func (p *Gateway) maxBodySize(subject string) int64 {
+	limit := p.maxBody
+	for _, route := range p.bodyLimits {
+		if subjectMatches(route.pattern, subject) {
+			limit = route.limit
+			break
+		}
+	}
+
+	if maxPayload := p.nc.MaxPayload(); maxPayload > 0 && (limit <= 0 || limit > maxPayload) {
+		limit = maxPayload
+	}
+	return limit
+}
+
+// limitedBody reads up to limit bytes of a request body, failing with ErrBodyTooLarge as soon as more are available
+type limitedBody struct {
+	r         io.ReadCloser
+	remaining int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		var probe [1]byte
+		if n, _ := l.r.Read(probe[:]); n > 0 {
+			return 0, ErrBodyTooLarge
+		}
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+func (l *limitedBody) Close() error {
+	return l.r.Close()
+}
+
+// limitBody rejects requests that declare a body larger than limit and bounds how much of the body will be read
+func limitBody(req *http.Request, limit int64) error {
+	if limit <= 0 || req.Body == nil {
+		return nil
+	}
+
+	if req.ContentLength > limit {
+		return errors.Wrapf(ErrBodyTooLarge, "content length of %v bytes exceeds limit of %v", req.ContentLength, limit)
+	}
+
+	req.Body = &limitedBody{r: req.Body, remaining: limit}
+	return nil
+}
+
+// checkPayload returns ErrBodyTooLarge if the encoded message exceeds the max_payload of the nats server
+func checkPayload(size int, maxPayload int64) error {
+	if maxPayload > 0 && int64(size) > maxPayload {
+		return errors.Wrapf(ErrBodyTooLarge, "message of %v bytes exceeds nats max_payload of %v", size, maxPayload)
+	}
+	return nil
+}
+
+// messageSize returns the size of the nats message as counted against max_payload, including its headers.  Headers
+// are serialized the way nats.go publishes them: a NATS/1.0 status line followed by http.Header's wire format
+func messageSize(msg *nats.Msg) int {
+	size := len(msg.Data)
+	if len(msg.Header) > 0 {
+		buf := &bytes.Buffer{}
+		buf.WriteString("NATS/1.0\r\n")
+		http.Header(msg.Header).Write(buf)
+		buf.WriteString("\r\n")
+		size += buf.Len()
+	}
+	return size
+}
+
+// tooLarge replies 413 and closes the connection rather than reading the remainder of the body
+func tooLarge(w http.ResponseWriter) {
+	w.Header().Set("Connection", "close")
+	http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+}
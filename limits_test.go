@@ -0,0 +1,95 @@
+package nats_proxy
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxBodySize(t *testing.T) {
+	calls := 0
+	h := func(ctx context.Context, subject string, message *Message) (*Message, error) {
+		calls++
+		return &Message{Status: http.StatusOK, Body: message.Body}, nil
+	}
+
+	gw, err := NewGateway(
+		WithHandler(h),
+		WithMaxBodySize(10),
+		WithRouteMaxBodySize("api.uploads.>", 100),
+	)
+	assert.Nil(t, err)
+	defer gw.Close()
+
+	testCases := map[string]struct {
+		Path    string
+		Size    int
+		Chunked bool
+		Status  int
+	}{
+		"within limit":         {Path: "/foo", Size: 10, Status: http.StatusOK},
+		"content length":       {Path: "/foo", Size: 11, Status: http.StatusRequestEntityTooLarge},
+		"chunked":              {Path: "/foo", Size: 11, Chunked: true, Status: http.StatusRequestEntityTooLarge},
+		"route within limit":   {Path: "/uploads/a", Size: 100, Chunked: true, Status: http.StatusOK},
+		"route exceeds limit":  {Path: "/uploads/a", Size: 101, Status: http.StatusRequestEntityTooLarge},
+		"exceeds max_payload":  {Path: "/uploads/a", Size: 2 << 20, Status: http.StatusRequestEntityTooLarge},
+		"route does not match": {Path: "/uploads", Size: 11, Status: http.StatusRequestEntityTooLarge},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			calls = 0
+			body := strings.Repeat("a", tc.Size)
+			req := httptest.NewRequest("POST", "http://localhost"+tc.Path, strings.NewReader(body))
+			if tc.Chunked {
+				req.Body = ioutil.NopCloser(bytes.NewReader([]byte(body)))
+				req.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			gw.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.Status, w.Code)
+			if tc.Status == http.StatusOK {
+				assert.Equal(t, body, w.Body.String())
+				assert.Equal(t, 1, calls)
+			} else {
+				assert.Equal(t, "close", w.Header().Get("Connection"))
+				assert.Equal(t, 0, calls)
+			}
+		})
+	}
+}
+
+func TestCheckPayload(t *testing.T) {
+	assert.Nil(t, checkPayload(10, 0))
+	assert.Nil(t, checkPayload(10, 10))
+	assert.Equal(t, ErrBodyTooLarge, errors.Cause(checkPayload(11, 10)))
+}
+
+func TestMessageSize(t *testing.T) {
+	nc, err := nats.Connect(nats.DefaultURL)
+	assert.Nil(t, err)
+	defer nc.Close()
+
+	msg := nats.NewMsg("limits.size")
+	msg.Header.Set("Nats-Proxy-Method", "POST")
+	msg.Header.Add("Nats-Proxy-Cookie", "a=b")
+	msg.Header.Add("Nats-Proxy-Cookie", "c=d")
+	msg.Header.Set("X-Multiline", "a\nb")
+	msg.Data = make([]byte, int(nc.MaxPayload())-messageSize(msg))
+
+	// exactly max_payload is accepted by nats.go; one more byte is not
+	assert.Equal(t, int(nc.MaxPayload()), messageSize(msg))
+	assert.Nil(t, nc.PublishMsg(msg))
+
+	msg.Data = append(msg.Data, 'x')
+	assert.Equal(t, nats.ErrMaxPayload, nc.PublishMsg(msg))
+}
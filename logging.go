@@ -0,0 +1,79 @@
+package nats_proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// Level specifies the verbosity of the Gateway's logging
+type Level int32
+
+const (
+	// LevelDebug logs every request routed over nats
+	LevelDebug Level = iota
+	// LevelInfo logs lifecycle events and configuration changes
+	LevelInfo
+	// LevelError logs failed requests
+	LevelError
+	// LevelOff disables logging
+	LevelOff
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelError: "error",
+	LevelOff:   "off",
+}
+
+// String implements fmt.Stringer
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("Level(%d)", int32(l))
+}
+
+// ParseLevel returns the Level with the specified name e.g. debug, info, error, or off
+func ParseLevel(name string) (Level, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for level, v := range levelNames {
+		if v == name {
+			return level, nil
+		}
+	}
+	return LevelOff, fmt.Errorf("unknown log level, %v", name)
+}
+
+// LogLevel returns the Gateway's current log level
+func (p *Gateway) LogLevel() Level {
+	return Level(atomic.LoadInt32(&p.logLevel))
+}
+
+// SetLogLevel changes the Gateway's log level; safe to call while the Gateway is serving requests
+func (p *Gateway) SetLogLevel(level Level) {
+	atomic.StoreInt32(&p.logLevel, int32(level))
+	p.logf(LevelInfo, "log level set to %v", level)
+}
+
+func (p *Gateway) logf(level Level, format string, args ...interface{}) {
+	if level < p.LogLevel() {
+		return
+	}
+	log.Printf(strings.ToUpper(level.String())+": "+format+"\n", args...)
+}
+
+type gatewayKey struct{}
+
+// logf logs on behalf of a Filter through the Gateway serving the request, subject to its log level; requests not
+// served by a Gateway are always logged
+func logf(ctx context.Context, level Level, format string, args ...interface{}) {
+	if p, ok := ctx.Value(gatewayKey{}).(*Gateway); ok {
+		p.logf(level, format, args...)
+		return
+	}
+	log.Printf(strings.ToUpper(level.String())+": "+format+"\n", args...)
+}
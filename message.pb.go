@@ -9,6 +9,10 @@ It is generated from these files:
 
 It has these top-level messages:
 	Cookie
+	Identity
+	Signature
+	ClientTLS
+	Client
 	Message
 */
 package nats_proxy
@@ -29,8 +33,15 @@ var _ = math.Inf
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
 type Cookie struct {
-	Value string `protobuf:"bytes,1,opt,name=value" json:"value,omitempty"`
-	Path  string `protobuf:"bytes,2,opt,name=path" json:"path,omitempty"`
+	Value    string `protobuf:"bytes,1,opt,name=value" json:"value,omitempty"`
+	Path     string `protobuf:"bytes,2,opt,name=path" json:"path,omitempty"`
+	Domain   string `protobuf:"bytes,3,opt,name=domain" json:"domain,omitempty"`
+	Expires  int64  `protobuf:"varint,4,opt,name=expires" json:"expires,omitempty"`
+	MaxAge   int32  `protobuf:"varint,5,opt,name=max_age,json=maxAge" json:"max_age,omitempty"`
+	Secure   bool   `protobuf:"varint,6,opt,name=secure" json:"secure,omitempty"`
+	HttpOnly bool   `protobuf:"varint,7,opt,name=http_only,json=httpOnly" json:"http_only,omitempty"`
+	SameSite string `protobuf:"bytes,8,opt,name=same_site,json=sameSite" json:"same_site,omitempty"`
+	Name     string `protobuf:"bytes,9,opt,name=name" json:"name,omitempty"`
 }
 
 func (m *Cookie) Reset()                    { *m = Cookie{} }
@@ -52,18 +63,256 @@ func (m *Cookie) GetPath() string {
 	return ""
 }
 
+func (m *Cookie) GetDomain() string {
+	if m != nil {
+		return m.Domain
+	}
+	return ""
+}
+
+func (m *Cookie) GetExpires() int64 {
+	if m != nil {
+		return m.Expires
+	}
+	return 0
+}
+
+func (m *Cookie) GetMaxAge() int32 {
+	if m != nil {
+		return m.MaxAge
+	}
+	return 0
+}
+
+func (m *Cookie) GetSecure() bool {
+	if m != nil {
+		return m.Secure
+	}
+	return false
+}
+
+func (m *Cookie) GetHttpOnly() bool {
+	if m != nil {
+		return m.HttpOnly
+	}
+	return false
+}
+
+func (m *Cookie) GetSameSite() string {
+	if m != nil {
+		return m.SameSite
+	}
+	return ""
+}
+
+func (m *Cookie) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+type Identity struct {
+	Subject string            `protobuf:"bytes,1,opt,name=subject" json:"subject,omitempty"`
+	Method  string            `protobuf:"bytes,2,opt,name=method" json:"method,omitempty"`
+	Claims  map[string]string `protobuf:"bytes,3,rep,name=claims" json:"claims,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+}
+
+func (m *Identity) Reset()                    { *m = Identity{} }
+func (m *Identity) String() string            { return proto.CompactTextString(m) }
+func (*Identity) ProtoMessage()               {}
+func (*Identity) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *Identity) GetSubject() string {
+	if m != nil {
+		return m.Subject
+	}
+	return ""
+}
+
+func (m *Identity) GetMethod() string {
+	if m != nil {
+		return m.Method
+	}
+	return ""
+}
+
+func (m *Identity) GetClaims() map[string]string {
+	if m != nil {
+		return m.Claims
+	}
+	return nil
+}
+
+type Signature struct {
+	KeyId     string `protobuf:"bytes,1,opt,name=key_id,json=keyId" json:"key_id,omitempty"`
+	Algorithm string `protobuf:"bytes,2,opt,name=algorithm" json:"algorithm,omitempty"`
+	Expires   int64  `protobuf:"varint,3,opt,name=expires" json:"expires,omitempty"`
+	Value     []byte `protobuf:"bytes,4,opt,name=value,proto3" json:"value,omitempty"`
+	Nonce     string `protobuf:"bytes,5,opt,name=nonce" json:"nonce,omitempty"`
+}
+
+func (m *Signature) Reset()                    { *m = Signature{} }
+func (m *Signature) String() string            { return proto.CompactTextString(m) }
+func (*Signature) ProtoMessage()               {}
+func (*Signature) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *Signature) GetKeyId() string {
+	if m != nil {
+		return m.KeyId
+	}
+	return ""
+}
+
+func (m *Signature) GetAlgorithm() string {
+	if m != nil {
+		return m.Algorithm
+	}
+	return ""
+}
+
+func (m *Signature) GetExpires() int64 {
+	if m != nil {
+		return m.Expires
+	}
+	return 0
+}
+
+func (m *Signature) GetValue() []byte {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+func (m *Signature) GetNonce() string {
+	if m != nil {
+		return m.Nonce
+	}
+	return ""
+}
+
+type ClientTLS struct {
+	Version            uint32 `protobuf:"varint,1,opt,name=version" json:"version,omitempty"`
+	CipherSuite        uint32 `protobuf:"varint,2,opt,name=cipher_suite,json=cipherSuite" json:"cipher_suite,omitempty"`
+	ServerName         string `protobuf:"bytes,3,opt,name=server_name,json=serverName" json:"server_name,omitempty"`
+	NegotiatedProtocol string `protobuf:"bytes,4,opt,name=negotiated_protocol,json=negotiatedProtocol" json:"negotiated_protocol,omitempty"`
+	PeerSubject        string `protobuf:"bytes,5,opt,name=peer_subject,json=peerSubject" json:"peer_subject,omitempty"`
+	PeerCertificate    []byte `protobuf:"bytes,6,opt,name=peer_certificate,json=peerCertificate,proto3" json:"peer_certificate,omitempty"`
+}
+
+func (m *ClientTLS) Reset()                    { *m = ClientTLS{} }
+func (m *ClientTLS) String() string            { return proto.CompactTextString(m) }
+func (*ClientTLS) ProtoMessage()               {}
+func (*ClientTLS) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *ClientTLS) GetVersion() uint32 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *ClientTLS) GetCipherSuite() uint32 {
+	if m != nil {
+		return m.CipherSuite
+	}
+	return 0
+}
+
+func (m *ClientTLS) GetServerName() string {
+	if m != nil {
+		return m.ServerName
+	}
+	return ""
+}
+
+func (m *ClientTLS) GetNegotiatedProtocol() string {
+	if m != nil {
+		return m.NegotiatedProtocol
+	}
+	return ""
+}
+
+func (m *ClientTLS) GetPeerSubject() string {
+	if m != nil {
+		return m.PeerSubject
+	}
+	return ""
+}
+
+func (m *ClientTLS) GetPeerCertificate() []byte {
+	if m != nil {
+		return m.PeerCertificate
+	}
+	return nil
+}
+
+type Client struct {
+	RemoteAddr string     `protobuf:"bytes,1,opt,name=remote_addr,json=remoteAddr" json:"remote_addr,omitempty"`
+	Host       string     `protobuf:"bytes,2,opt,name=host" json:"host,omitempty"`
+	Scheme     string     `protobuf:"bytes,3,opt,name=scheme" json:"scheme,omitempty"`
+	Proto      string     `protobuf:"bytes,4,opt,name=proto" json:"proto,omitempty"`
+	Tls        *ClientTLS `protobuf:"bytes,5,opt,name=tls" json:"tls,omitempty"`
+}
+
+func (m *Client) Reset()                    { *m = Client{} }
+func (m *Client) String() string            { return proto.CompactTextString(m) }
+func (*Client) ProtoMessage()               {}
+func (*Client) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *Client) GetRemoteAddr() string {
+	if m != nil {
+		return m.RemoteAddr
+	}
+	return ""
+}
+
+func (m *Client) GetHost() string {
+	if m != nil {
+		return m.Host
+	}
+	return ""
+}
+
+func (m *Client) GetScheme() string {
+	if m != nil {
+		return m.Scheme
+	}
+	return ""
+}
+
+func (m *Client) GetProto() string {
+	if m != nil {
+		return m.Proto
+	}
+	return ""
+}
+
+func (m *Client) GetTls() *ClientTLS {
+	if m != nil {
+		return m.Tls
+	}
+	return nil
+}
+
 type Message struct {
-	Status  int32              `protobuf:"varint,1,opt,name=status" json:"status,omitempty"`
-	Method  string             `protobuf:"bytes,2,opt,name=method" json:"method,omitempty"`
-	Header  map[string]string  `protobuf:"bytes,3,rep,name=header" json:"header,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
-	Cookies map[string]*Cookie `protobuf:"bytes,4,rep,name=cookies" json:"cookies,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
-	Body    []byte             `protobuf:"bytes,5,opt,name=body,proto3" json:"body,omitempty"`
+	Status         int32              `protobuf:"varint,1,opt,name=status" json:"status,omitempty"`
+	Method         string             `protobuf:"bytes,2,opt,name=method" json:"method,omitempty"`
+	Header         map[string]string  `protobuf:"bytes,3,rep,name=header" json:"header,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Cookies        map[string]*Cookie `protobuf:"bytes,4,rep,name=cookies" json:"cookies,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Body           []byte             `protobuf:"bytes,5,opt,name=body,proto3" json:"body,omitempty"`
+	Identity       *Identity          `protobuf:"bytes,6,opt,name=identity" json:"identity,omitempty"`
+	Signature      *Signature         `protobuf:"bytes,7,opt,name=signature" json:"signature,omitempty"`
+	Encoding       string             `protobuf:"bytes,8,opt,name=encoding" json:"encoding,omitempty"`
+	AcceptEncoding []string           `protobuf:"bytes,9,rep,name=accept_encoding,json=acceptEncoding" json:"accept_encoding,omitempty"`
+	Client         *Client            `protobuf:"bytes,10,opt,name=client" json:"client,omitempty"`
 }
 
 func (m *Message) Reset()                    { *m = Message{} }
 func (m *Message) String() string            { return proto.CompactTextString(m) }
 func (*Message) ProtoMessage()               {}
-func (*Message) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+func (*Message) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
 
 func (m *Message) GetStatus() int32 {
 	if m != nil {
@@ -100,29 +349,99 @@ func (m *Message) GetBody() []byte {
 	return nil
 }
 
+func (m *Message) GetIdentity() *Identity {
+	if m != nil {
+		return m.Identity
+	}
+	return nil
+}
+
+func (m *Message) GetSignature() *Signature {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+func (m *Message) GetEncoding() string {
+	if m != nil {
+		return m.Encoding
+	}
+	return ""
+}
+
+func (m *Message) GetAcceptEncoding() []string {
+	if m != nil {
+		return m.AcceptEncoding
+	}
+	return nil
+}
+
+func (m *Message) GetClient() *Client {
+	if m != nil {
+		return m.Client
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*Cookie)(nil), "nats_proxy.Cookie")
+	proto.RegisterType((*Identity)(nil), "nats_proxy.Identity")
+	proto.RegisterType((*Signature)(nil), "nats_proxy.Signature")
+	proto.RegisterType((*ClientTLS)(nil), "nats_proxy.ClientTLS")
+	proto.RegisterType((*Client)(nil), "nats_proxy.Client")
 	proto.RegisterType((*Message)(nil), "nats_proxy.Message")
 }
 
 func init() { proto.RegisterFile("message.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 253 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x91, 0xb1, 0x4e, 0xc3, 0x30,
-	0x10, 0x86, 0x95, 0xa4, 0x49, 0xc5, 0xa5, 0x48, 0xe8, 0x84, 0x90, 0xd5, 0x85, 0xa8, 0x53, 0xa6,
-	0x0c, 0x61, 0x00, 0xba, 0x22, 0x24, 0x16, 0x18, 0xf2, 0x02, 0xc8, 0x25, 0x27, 0x82, 0x4a, 0xeb,
-	0xc8, 0x76, 0x11, 0x7e, 0x48, 0xde, 0x09, 0xe5, 0xec, 0xaa, 0x1e, 0xba, 0xdd, 0x59, 0xf7, 0xfd,
-	0xf7, 0xff, 0x67, 0xb8, 0xdc, 0x91, 0x31, 0xf2, 0x93, 0x9a, 0x51, 0x2b, 0xab, 0x10, 0xf6, 0xd2,
-	0x9a, 0xf7, 0x51, 0xab, 0x5f, 0xb7, 0x6a, 0xa1, 0x78, 0x52, 0x6a, 0xfb, 0x45, 0x78, 0x0d, 0xf9,
-	0x8f, 0xfc, 0x3e, 0x90, 0x48, 0xaa, 0xa4, 0xbe, 0xe8, 0x7c, 0x83, 0x08, 0xb3, 0x51, 0xda, 0x41,
-	0xa4, 0xfc, 0xc8, 0xf5, 0xea, 0x2f, 0x85, 0xf9, 0xab, 0x57, 0xc4, 0x1b, 0x28, 0x8c, 0x95, 0xf6,
-	0x60, 0x18, 0xcb, 0xbb, 0xd0, 0x4d, 0xef, 0x3b, 0xb2, 0x83, 0xea, 0x03, 0x19, 0x3a, 0xbc, 0x87,
-	0x62, 0x20, 0xd9, 0x93, 0x16, 0x59, 0x95, 0xd5, 0x65, 0x7b, 0xdb, 0x9c, 0xcc, 0x34, 0x41, 0xb4,
-	0x79, 0xe1, 0x89, 0xe7, 0xbd, 0xd5, 0xae, 0x0b, 0xe3, 0xb8, 0x86, 0xf9, 0x07, 0x1b, 0x35, 0x62,
-	0xc6, 0x64, 0x75, 0x8e, 0xf4, 0x59, 0x8c, 0x47, 0x8f, 0xc0, 0x14, 0x62, 0xa3, 0x7a, 0x27, 0xf2,
-	0x2a, 0xa9, 0x17, 0x1d, 0xd7, 0xcb, 0x47, 0x28, 0xa3, 0x35, 0x78, 0x05, 0xd9, 0x96, 0x5c, 0xc8,
-	0x3e, 0x95, 0xa7, 0x7b, 0xa4, 0xd1, 0x3d, 0xd6, 0xe9, 0x43, 0xb2, 0x7c, 0x83, 0x45, 0xbc, 0xe7,
-	0x0c, 0x5b, 0xc7, 0x6c, 0xd9, 0x62, 0x6c, 0xd5, 0xa3, 0x91, 0xde, 0xa6, 0xe0, 0x6f, 0xb9, 0xfb,
-	0x0f, 0x00, 0x00, 0xff, 0xff, 0x8c, 0x26, 0x9b, 0x59, 0xa7, 0x01, 0x00, 0x00,
+	// 739 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x54, 0x4d, 0x6e, 0xdb, 0x46,
+	0x14, 0x06, 0x45, 0x8b, 0x12, 0x9f, 0xe4, 0xda, 0x98, 0xda, 0x2d, 0xe1, 0x16, 0xb0, 0xaa, 0x8d,
+	0xd5, 0x2e, 0xd4, 0xc2, 0x5e, 0xd4, 0xf5, 0xce, 0x10, 0x0c, 0xd4, 0x40, 0xeb, 0x16, 0xa3, 0xee,
+	0x89, 0x31, 0xf9, 0x2a, 0x4e, 0x44, 0x72, 0x08, 0xce, 0xc8, 0x10, 0x2f, 0x90, 0x13, 0xe4, 0x0a,
+	0x39, 0x40, 0xce, 0x94, 0x4d, 0x8e, 0x11, 0xcc, 0x0f, 0x25, 0x26, 0x76, 0x16, 0xd9, 0xcd, 0xfb,
+	0xe6, 0xcd, 0xfb, 0xfb, 0xbe, 0x37, 0x70, 0x58, 0xa0, 0x94, 0x6c, 0x85, 0xf3, 0xaa, 0x16, 0x4a,
+	0x10, 0x28, 0x99, 0x92, 0x71, 0x55, 0x8b, 0x6d, 0x33, 0x7d, 0xef, 0x41, 0xb0, 0x10, 0x62, 0xcd,
+	0x91, 0x9c, 0x40, 0xff, 0x89, 0xe5, 0x1b, 0x8c, 0xbc, 0x89, 0x37, 0x0b, 0xa9, 0x35, 0x08, 0x81,
+	0x83, 0x8a, 0xa9, 0x2c, 0xea, 0x19, 0xd0, 0x9c, 0xc9, 0x77, 0x10, 0xa4, 0xa2, 0x60, 0xbc, 0x8c,
+	0x7c, 0x83, 0x3a, 0x8b, 0x44, 0x30, 0xc0, 0x6d, 0xc5, 0x6b, 0x94, 0xd1, 0xc1, 0xc4, 0x9b, 0xf9,
+	0xb4, 0x35, 0xc9, 0xf7, 0x30, 0x28, 0xd8, 0x36, 0x66, 0x2b, 0x8c, 0xfa, 0x13, 0x6f, 0xd6, 0xa7,
+	0x41, 0xc1, 0xb6, 0xb7, 0x2b, 0xd4, 0xa1, 0x24, 0x26, 0x9b, 0x1a, 0xa3, 0x60, 0xe2, 0xcd, 0x86,
+	0xd4, 0x59, 0xe4, 0x07, 0x08, 0x33, 0xa5, 0xaa, 0x58, 0x94, 0x79, 0x13, 0x0d, 0xcc, 0xd5, 0x50,
+	0x03, 0xff, 0x94, 0x79, 0xa3, 0x2f, 0x25, 0x2b, 0x30, 0x96, 0x5c, 0x61, 0x34, 0x34, 0x25, 0x0c,
+	0x35, 0xb0, 0xe4, 0xca, 0x14, 0x5c, 0xb2, 0x02, 0xa3, 0xd0, 0x16, 0xac, 0xcf, 0xd3, 0x77, 0x1e,
+	0x0c, 0xef, 0x53, 0x2c, 0x15, 0x57, 0x8d, 0xae, 0x52, 0x6e, 0x1e, 0x5f, 0x61, 0xa2, 0x5c, 0xa7,
+	0xad, 0xa9, 0x8b, 0x29, 0x50, 0x65, 0x22, 0x75, 0xdd, 0x3a, 0x8b, 0x5c, 0x43, 0x90, 0xe4, 0x8c,
+	0x17, 0x32, 0xf2, 0x27, 0xfe, 0x6c, 0x74, 0x39, 0x99, 0xef, 0x27, 0x38, 0x6f, 0xe3, 0xce, 0x17,
+	0xc6, 0xe5, 0xae, 0x54, 0x75, 0x43, 0x9d, 0xff, 0xd9, 0x1f, 0x30, 0xea, 0xc0, 0xe4, 0x18, 0xfc,
+	0x35, 0x36, 0x2e, 0xad, 0x3e, 0xee, 0x87, 0xde, 0xeb, 0x0c, 0xfd, 0xa6, 0x77, 0xed, 0x4d, 0x5f,
+	0x7b, 0x10, 0x2e, 0xf9, 0xaa, 0x64, 0x4a, 0xcf, 0xe3, 0x14, 0x82, 0x35, 0x36, 0x31, 0x4f, 0x5b,
+	0x76, 0xd6, 0xd8, 0xdc, 0xa7, 0xe4, 0x47, 0x08, 0x59, 0xbe, 0x12, 0x35, 0x57, 0x59, 0xe1, 0x42,
+	0xec, 0x81, 0x2e, 0x1f, 0xfe, 0xa7, 0x7c, 0xec, 0xd2, 0x6a, 0x9e, 0xc6, 0x2d, 0xd7, 0x27, 0xd0,
+	0x2f, 0x45, 0x99, 0x58, 0x8e, 0x42, 0x6a, 0x8d, 0xe9, 0x07, 0x0f, 0xc2, 0x45, 0xce, 0xb1, 0x54,
+	0xff, 0xfd, 0xb5, 0xd4, 0x31, 0x9f, 0xb0, 0x96, 0x5c, 0x94, 0xa6, 0x92, 0x43, 0xda, 0x9a, 0xe4,
+	0x27, 0x18, 0x27, 0xbc, 0xca, 0xb0, 0x8e, 0xe5, 0x46, 0x13, 0xd3, 0x33, 0xd7, 0x23, 0x8b, 0x2d,
+	0x35, 0x44, 0xce, 0x61, 0x24, 0xb1, 0x7e, 0xc2, 0x3a, 0x36, 0x14, 0x59, 0xf5, 0x80, 0x85, 0x1e,
+	0x58, 0x81, 0xe4, 0x57, 0xf8, 0xb6, 0xc4, 0x95, 0x50, 0x9c, 0x29, 0x4c, 0x63, 0x23, 0xd7, 0x44,
+	0xe4, 0xa6, 0xca, 0x90, 0x92, 0xfd, 0xd5, 0xbf, 0xee, 0x46, 0x27, 0xad, 0xd0, 0xa4, 0xb4, 0x8c,
+	0xda, 0xca, 0x47, 0x1a, 0x5b, 0x3a, 0x56, 0x7f, 0x86, 0x63, 0xe3, 0x92, 0x60, 0xad, 0xf8, 0xff,
+	0x3c, 0x61, 0xca, 0x8a, 0x6d, 0x4c, 0x8f, 0x34, 0xbe, 0xd8, 0xc3, 0xd3, 0x37, 0x7a, 0x1b, 0x4c,
+	0xab, 0xba, 0xd4, 0x1a, 0x0b, 0xa1, 0x30, 0x66, 0x69, 0x5a, 0xbb, 0xa9, 0x83, 0x85, 0x6e, 0xd3,
+	0xb4, 0xd6, 0x3a, 0xcb, 0x84, 0x54, 0xed, 0x62, 0xe8, 0xb3, 0x51, 0x73, 0x92, 0xe1, 0xae, 0x35,
+	0x67, 0xe9, 0xc1, 0x9a, 0x5e, 0x5c, 0x23, 0xd6, 0x20, 0x17, 0xe0, 0xab, 0x5c, 0x9a, 0x92, 0x47,
+	0x97, 0xa7, 0x5d, 0x4d, 0xed, 0xc6, 0x4d, 0xb5, 0xc7, 0xf4, 0xed, 0x01, 0x0c, 0xfe, 0xb6, 0x2b,
+	0x6c, 0x52, 0x28, 0xa6, 0x36, 0xd2, 0x94, 0xd4, 0xa7, 0xce, 0xfa, 0xa2, 0x76, 0x7f, 0x87, 0x20,
+	0x43, 0x96, 0x62, 0xed, 0xb4, 0x7b, 0xde, 0xcd, 0xe3, 0x82, 0xce, 0xff, 0x34, 0x1e, 0x4e, 0xba,
+	0xd6, 0x9d, 0xdc, 0xc0, 0x20, 0x31, 0x1f, 0x83, 0x5e, 0xe6, 0x67, 0xaa, 0x6f, 0x5f, 0xda, 0xbf,
+	0xc3, 0xa9, 0xbe, 0x7d, 0xa0, 0x67, 0xf3, 0x28, 0xd2, 0xc6, 0xb4, 0x36, 0xa6, 0xe6, 0x4c, 0x7e,
+	0x83, 0x21, 0x77, 0xab, 0x62, 0xc6, 0x3f, 0xba, 0x3c, 0x79, 0x69, 0x8d, 0xe8, 0xce, 0x8b, 0x5c,
+	0x41, 0x28, 0xdb, 0x05, 0x88, 0x06, 0xcf, 0xa7, 0xb4, 0xdb, 0x0e, 0xba, 0xf7, 0x23, 0x67, 0x30,
+	0xc4, 0x32, 0x11, 0x29, 0x2f, 0x57, 0xed, 0xd7, 0xd0, 0xda, 0xe4, 0x02, 0x8e, 0x58, 0x92, 0x60,
+	0xa5, 0xe2, 0x9d, 0x4b, 0x38, 0xf1, 0x67, 0x21, 0xfd, 0xc6, 0xc2, 0x77, 0xad, 0xe3, 0x2f, 0x7a,
+	0xe1, 0x35, 0x05, 0x11, 0x98, 0xb4, 0xe4, 0x39, 0x39, 0xd4, 0x79, 0xe8, 0x15, 0xef, 0x8c, 0xef,
+	0x6b, 0x56, 0xfc, 0xec, 0x01, 0xc6, 0xdd, 0xf9, 0xbd, 0xf0, 0x76, 0xd6, 0x7d, 0xfb, 0x79, 0x1d,
+	0xe6, 0x69, 0x27, 0xde, 0x63, 0x60, 0x74, 0x75, 0xf5, 0x71, 0x00, 0x15, 0x8f, 0x3e, 0xc2, 0xf0,
+	0x05, 0x00, 0x00,
 }
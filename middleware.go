@@ -0,0 +1,132 @@
+package nats_proxy
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Writer writes the reply to a request served by the Gateway back to the http client
+type Writer func(w http.ResponseWriter, req *http.Request, out *Message)
+
+// ResponseFilter wraps the Writer that returns replies to http clients.  Unlike a Filter, it receives the original
+// *http.Request and the http.ResponseWriter, so it may add headers based on the final status, wrap w to transform the
+// body as it is written or write a different response entirely.  ResponseFilters see every reply, including errors,
+// preflights and the paths served by the Gateway itself, before the Gateway applies its header rules, CORS and
+// response compression; headers they set are subject to the header rules and they always write the plain body
+type ResponseFilter func(Writer) Writer
+
+// ChainWriter folds a series of ResponseFilters with a Writer to construct a new Writer
+func ChainWriter(w Writer, filters ...ResponseFilter) Writer {
+	for i := len(filters) - 1; i >= 0; i-- {
+		w = filters[i](w)
+	}
+	return w
+}
+
+type replyKey struct{}
+
+// Middleware converts standard http middleware into a ResponseFilter.  The http.Handler passed to mw writes the reply
+func Middleware(mw func(http.Handler) http.Handler) ResponseFilter {
+	return func(next Writer) Writer {
+		h := mw(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			out, _ := req.Context().Value(replyKey{}).(*Message)
+			next(w, req, out)
+		}))
+
+		return func(w http.ResponseWriter, req *http.Request, out *Message) {
+			h.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), replyKey{}, out)))
+		}
+	}
+}
+
+// middlewareCall holds the state of a single request passing through a MiddlewareFilter
+type middlewareCall struct {
+	subject string
+	message *Message
+	err     error
+}
+
+type middlewareKey struct{}
+
+// MiddlewareFilter converts standard http middleware into a Filter.  The http.Handler passed to mw sends the message
+// on to the remaining Filters and nats, and whatever is written to the http.ResponseWriter, whether by the
+// http.Handler or by mw itself, becomes the reply.  Values mw adds to the request's context are visible to the
+// remaining Filters
+func MiddlewareFilter(mw func(http.Handler) http.Handler) Filter {
+	return func(h Handler) Handler {
+		handler := mw(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			call := req.Context().Value(middlewareKey{}).(*middlewareCall)
+
+			out, err := h(req.Context(), call.subject, call.message)
+			if err != nil {
+				call.err = err
+				return
+			}
+			if out == nil {
+				out = &Message{Status: http.StatusBadGateway} // neither a reply nor an error
+			}
+			writeMessage(w, out)
+		}))
+
+		return func(ctx context.Context, subject string, message *Message) (*Message, error) {
+			req, ok := HTTPRequest(ctx)
+			if !ok {
+				return h(ctx, subject, message)
+			}
+
+			call := &middlewareCall{subject: subject, message: message}
+			w := &messageWriter{header: http.Header{}}
+			handler.ServeHTTP(w, req.WithContext(context.WithValue(ctx, middlewareKey{}, call)))
+			if call.err != nil {
+				return nil, call.err
+			}
+			return w.message(), nil
+		}
+	}
+}
+
+// messageWriter is an http.ResponseWriter that records the response as a *Message
+type messageWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *messageWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *messageWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(p)
+}
+
+func (w *messageWriter) message() *Message {
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	out := &Message{
+		Status:  int32(status),
+		Header:  map[string]string{},
+		Cookies: readSetCookies(w.header["Set-Cookie"]),
+	}
+	for key, values := range w.header {
+		if key != "Set-Cookie" {
+			out.Header[key] = strings.Join(values, ", ")
+		}
+	}
+	if w.body.Len() > 0 {
+		out.Body = w.body.Bytes()
+	}
+	return out
+}
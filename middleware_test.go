@@ -0,0 +1,194 @@
+package nats_proxy
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseFilters(t *testing.T) {
+	h := func(ctx context.Context, subject string, message *Message) (*Message, error) {
+		if subject == "api.missing" {
+			return &Message{Status: http.StatusNotFound, Body: []byte("missing")}, nil
+		}
+		return &Message{Status: http.StatusOK, Header: map[string]string{"Content-Type": "text/plain"}, Body: []byte("hello")}, nil
+	}
+
+	// adds a header based on the final status
+	noStore := func(next Writer) Writer {
+		return func(w http.ResponseWriter, req *http.Request, out *Message) {
+			if out.Status >= http.StatusBadRequest {
+				w.Header().Set("Cache-Control", "no-store")
+			}
+			next(w, req, out)
+		}
+	}
+
+	// standard middleware wrapping the ResponseWriter to transform the body
+	upper := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("X-Frame-Options", "DENY")
+			next.ServeHTTP(upperWriter{w}, req)
+		})
+	}
+
+	gw, err := NewGateway(
+		WithHandler(h),
+		WithResponseFilters(noStore, Middleware(upper)),
+	)
+	assert.Nil(t, err)
+	defer gw.Close()
+
+	w := httptest.NewRecorder()
+	gw.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/hello", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "HELLO", w.Body.String())
+	assert.Equal(t, "DENY", w.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "", w.Header().Get("Cache-Control"))
+
+	w = httptest.NewRecorder()
+	gw.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/missing", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "MISSING", w.Body.String())
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+}
+
+func TestResponseFiltersEncoded(t *testing.T) {
+	content := strings.Repeat("hello world ", 200)
+	h := func(ctx context.Context, subject string, message *Message) (*Message, error) {
+		if subject == "api.fail" {
+			return nil, errors.New("boom")
+		}
+		return &Message{Status: http.StatusOK, Header: map[string]string{"Content-Type": "text/plain"}, Body: []byte(content)}, nil
+	}
+
+	upper := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("X-Internal-Debug", "1")
+			next.ServeHTTP(upperWriter{w}, req)
+		})
+	}
+
+	gw, err := NewGateway(
+		WithHandler(h),
+		WithResponseFilters(Middleware(upper)),
+		WithResponseCompression(ResponseCompression{}),
+		WithHeaderRules(HeaderRule{Effect: Deny, Headers: []string{"X-Internal-*"}, Direction: DirectionResponse}),
+	)
+	assert.Nil(t, err)
+	defer gw.Close()
+
+	// filters transform the plain body, which is compressed afterwards
+	req := httptest.NewRequest("GET", "http://localhost/hello", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	gw.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "", w.Header().Get("X-Internal-Debug"))
+
+	r, err := gzip.NewReader(w.Body)
+	assert.Nil(t, err)
+	data, err := ioutil.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, strings.ToUpper(content), string(data))
+
+	// errors pass through the same filters
+	w = httptest.NewRecorder()
+	gw.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/fail", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "BOOM\n", w.Body.String())
+	assert.Equal(t, "", w.Header().Get("X-Internal-Debug"))
+}
+
+type upperWriter struct {
+	http.ResponseWriter
+}
+
+func (w upperWriter) Write(p []byte) (int, error) {
+	return w.ResponseWriter.Write([]byte(strings.ToUpper(string(p))))
+}
+
+type userKey struct{}
+
+func TestMiddlewareFilter(t *testing.T) {
+	h := func(ctx context.Context, subject string, message *Message) (*Message, error) {
+		if subject == "api.fail" {
+			return nil, errors.New("boom")
+		}
+		if subject == "api.empty" {
+			return nil, nil
+		}
+		user, _ := ctx.Value(userKey{}).(string)
+		return &Message{
+			Status:  http.StatusOK,
+			Header:  map[string]string{"X-User": user},
+			Cookies: map[string]*Cookie{"session": {Value: "abc", HttpOnly: true}},
+			Body:    []byte("hello"),
+		}, nil
+	}
+
+	auth := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			user := req.Header.Get("X-Token")
+			if user == "" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("X-Authenticated", "true")
+			next.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), userKey{}, user)))
+		})
+	}
+
+	gw, err := NewGateway(
+		WithHandler(h),
+		WithFilters(MiddlewareFilter(auth)),
+	)
+	assert.Nil(t, err)
+	defer gw.Close()
+
+	t.Run("rejected", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		gw.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/hello", nil))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, "unauthorized\n", w.Body.String())
+	})
+
+	t.Run("allowed", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://localhost/hello", nil)
+		req.Header.Set("X-Token", "alice")
+		w := httptest.NewRecorder()
+		gw.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "hello", w.Body.String())
+		assert.Equal(t, "alice", w.Header().Get("X-User"))
+		assert.Equal(t, "true", w.Header().Get("X-Authenticated"))
+		assert.Equal(t, "session=abc; HttpOnly", w.Header().Get("Set-Cookie"))
+	})
+
+	t.Run("error", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://localhost/fail", nil)
+		req.Header.Set("X-Token", "alice")
+		w := httptest.NewRecorder()
+		gw.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, "boom\n", w.Body.String())
+		assert.Equal(t, "", w.Header().Get("X-Authenticated"))
+	})
+
+	t.Run("no reply", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://localhost/empty", nil)
+		req.Header.Set("X-Token", "alice")
+		w := httptest.NewRecorder()
+		gw.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+		assert.Equal(t, "true", w.Header().Get("X-Authenticated"))
+	})
+}
@@ -6,7 +6,7 @@ import (
 	"strings"
 	"time"
 
-	"github.com/nats-io/go-nats"
+	"github.com/nats-io/nats.go"
 	"github.com/pkg/errors"
 )
 
@@ -14,11 +14,17 @@ const (
 	// DefaultSubject provides the name of the root subject nats-proxy will bind to
 	DefaultSubject = "api"
 
-	// DefaultTimeout specifies the maximum amount of time a request may take
+	// DefaultTimeout specifies the maximum amount of time a request may take, including every retry and hedged request
 	DefaultTimeout = time.Second * 10
 
 	// DefaultQueue specifies the name of the queue for Conn.QueueSubscribe
 	DefaultQueue = "service"
+
+	// DefaultDrainTimeout specifies how long a Router or Gateway will wait for in-flight messages to complete on shutdown
+	DefaultDrainTimeout = time.Second * 30
+
+	// drainInterval specifies how frequently a draining Router checks for completion
+	drainInterval = time.Millisecond * 50
 )
 
 type config struct {
@@ -26,11 +32,37 @@ type config struct {
 	url            string
 	queue          string
 	nc             *nats.Conn
+	ownsConn       bool          // true if nc was created by readConfig
+	connClosed     chan struct{} // closed once an owned nc has been closed
 	filters        []Filter
-	headers        map[string]struct{}
-	cookies        map[string]struct{}
+	filterNames    []string
+	writers        []ResponseFilter
+	instrumented   map[string]Instrumented
+	headerRules    []HeaderRule
 	subject        string
+	healthPath     string
+	readyPath      string
+	pings          []string
+	servicesPath   string
+	controlSubject string
+	heartbeat      time.Duration
+	version        string
+	routes         []string
 	timeout        time.Duration
+	drainTimeout   time.Duration
+	logLevel       Level
+	hedge          bool
+	hedgeDelay     time.Duration
+	cors           *CORS
+	encoding       *ResponseCompression
+	codec          Codec
+	trustedProxies []string
+	maxBody        int64
+	bodyLimits     []bodyLimit
+	compression    compression
+	signingKey     *Key
+	signatureTTL   time.Duration
+	verifyKeys     map[string]Key
 	returnNotFound bool
 	onError        func(err error, w http.ResponseWriter, req *http.Request)
 }
@@ -67,42 +99,106 @@ func WithSubject(subject string) Option {
 	}
 }
 
-// WithHeaders specifies http headers that should be passed across nats
+// WithHeaders specifies http headers that should be passed across nats, in addition to
+// ```nats_proxy.DefaultHeaders```; names may be globs e.g. X-Custom-*
 func WithHeaders(headers ...string) Option {
 	return func(p *config) {
-		for _, item := range headers {
-			if v := strings.TrimSpace(item); v != "" {
-				p.headers[v] = struct{}{}
-			}
+		if patterns := trimmed(headers); len(patterns) > 0 {
+			p.headerRules = append(p.headerRules, HeaderRule{Effect: Allow, Headers: patterns, Direction: DirectionRequest})
 		}
 	}
 }
 
+// WithHeaderRules specifies rules that allow or deny headers and cookies by pattern, direction and subject e.g. to
+// strip Authorization from requests to some routes; see HeaderRule
+func WithHeaderRules(rules ...HeaderRule) Option {
+	return func(p *config) {
+		p.headerRules = append(p.headerRules, rules...)
+	}
+}
+
 // WithFilters allows gateway filters to be specified; applies ONLY to Gateway
 func WithFilters(filters ...Filter) Option {
 	return func(p *config) {
-		p.filters = append(p.filters, filters...)
+		for _, filter := range filters {
+			p.filters = append(p.filters, filter)
+			p.filterNames = append(p.filterNames, filterName(filter))
+		}
+	}
+}
+
+// WithResponseFilters allows filters over the http reply to be specified; applies ONLY to Gateway
+func WithResponseFilters(filters ...ResponseFilter) Option {
+	return func(p *config) {
+		p.writers = append(p.writers, filters...)
 	}
 }
 
-// WithCookies specifies the specific cookies that should be passed across nats
+// WithInstrumented adds the Filter provided by a stateful component, such as a Retrier, to the Gateway and reports the
+// component's stats on the admin api under the specified name; applies ONLY to Gateway
+func WithInstrumented(name string, i Instrumented) Option {
+	return func(p *config) {
+		p.filters = append(p.filters, i.Filter())
+		p.filterNames = append(p.filterNames, name)
+		p.instrumented[name] = i
+	}
+}
+
+// WithCookies specifies the specific cookies that should be passed across nats; names may be globs e.g. session_*
 func WithCookies(cookies ...string) Option {
 	return func(p *config) {
-		for _, item := range cookies {
-			if v := strings.TrimSpace(item); v != "" {
-				p.cookies[v] = struct{}{}
-			}
+		if patterns := trimmed(cookies); len(patterns) > 0 {
+			p.headerRules = append(p.headerRules, HeaderRule{Effect: Allow, Cookies: patterns, Direction: DirectionRequest})
+		}
+	}
+}
+
+// trimmed returns the non-empty items with surrounding whitespace removed
+func trimmed(items []string) []string {
+	var values []string
+	for _, item := range items {
+		if v := strings.TrimSpace(item); v != "" {
+			values = append(values, v)
 		}
 	}
+	return values
 }
 
-// WithTimeout specifies how long a single request can take; defaults to ```nats_proxy.DefaultTimeout```
+// WithTimeout specifies how long a single request can take; defaults to ```nats_proxy.DefaultTimeout```.  The timeout
+// bounds the request as the client sees it, so every Filter, retry, hedged request and circuit breaker wait shares the
+// one budget rather than each attempt getting its own.  Use ```nats_proxy.RetryAttemptTimeout``` to bound attempts
 func WithTimeout(d time.Duration) Option {
 	return func(p *config) {
 		p.timeout = d
 	}
 }
 
+// WithDrainTimeout specifies how long a ```*nats_proxy.Router``` will wait for in-flight messages to be handled and
+// replied to once its context is done, and how long ```Gateway.Close``` will wait for in-flight requests; defaults to
+// ```nats_proxy.DefaultDrainTimeout```.  A value of 0 disables draining and unsubscribes immediately
+func WithDrainTimeout(d time.Duration) Option {
+	return func(p *config) {
+		p.drainTimeout = d
+	}
+}
+
+// WithHedging enables request hedging for GET, HEAD and OPTIONS requests: if no reply arrives within delay, a second
+// request is sent and the first reply wins.  A delay of 0 derives the delay from the 95th percentile of recent
+// latencies; applies ONLY to Gateway
+func WithHedging(delay time.Duration) Option {
+	return func(p *config) {
+		p.hedge = true
+		p.hedgeDelay = delay
+	}
+}
+
+// WithLogLevel specifies the Gateway's initial log level; defaults to ```nats_proxy.LevelOff```
+func WithLogLevel(level Level) Option {
+	return func(p *config) {
+		p.logLevel = level
+	}
+}
+
 // WithNotFoundEnabled specifies whether or not the ```*nats_proxy.Router``` should respond with 404 Not Found for
 // routes it's unable to handle
 func WithNotFoundEnabled(enabled bool) Option {
@@ -111,6 +207,179 @@ func WithNotFoundEnabled(enabled bool) Option {
 	}
 }
 
+// WithHealthPath enables the Gateway's liveness check on the specified path e.g. ```nats_proxy.DefaultHealthPath```.
+// The check is disabled by default, so every path is routed over nats
+func WithHealthPath(path string) Option {
+	return func(p *config) {
+		p.healthPath = path
+	}
+}
+
+// WithReadyPath enables the Gateway's readiness check on the specified path e.g. ```nats_proxy.DefaultReadyPath```.
+// The check is disabled by default, so every path is routed over nats
+func WithReadyPath(path string) Option {
+	return func(p *config) {
+		p.readyPath = path
+	}
+}
+
+// WithPings specifies downstream subjects that must reply with a status other than 5xx before the Gateway reports
+// itself ready
+func WithPings(subjects ...string) Option {
+	return func(p *config) {
+		for _, item := range subjects {
+			if v := strings.TrimSpace(item); v != "" {
+				p.pings = append(p.pings, v)
+			}
+		}
+	}
+}
+
+// WithServicesPath specifies the path the Gateway lists the live services mounted under its subject root on.  Disabled
+// by default
+func WithServicesPath(path string) Option {
+	return func(p *config) {
+		p.servicesPath = path
+	}
+}
+
+// WithControlSubject specifies the subject Routers announce themselves on; defaults to
+// ```nats_proxy.DefaultControlSubject```
+func WithControlSubject(subject string) Option {
+	return func(p *config) {
+		if subject != "" {
+			p.controlSubject = subject
+		}
+	}
+}
+
+// WithHeartbeat specifies how frequently a ```*nats_proxy.Router``` announces itself; defaults to
+// ```nats_proxy.DefaultHeartbeatInterval```.  A value of 0 disables announcements
+func WithHeartbeat(d time.Duration) Option {
+	return func(p *config) {
+		p.heartbeat = d
+	}
+}
+
+// WithVersion specifies the version a ```*nats_proxy.Router``` announces for its service
+func WithVersion(version string) Option {
+	return func(p *config) {
+		p.version = version
+	}
+}
+
+// WithRoutes specifies the routes a ```*nats_proxy.Router``` announces for its service
+func WithRoutes(routes ...string) Option {
+	return func(p *config) {
+		p.routes = append(p.routes, routes...)
+	}
+}
+
+// WithMaxBodySize specifies the maximum size of a request body; larger requests are rejected with 413 before anything
+// is published to nats.  Regardless of this limit, bodies may not exceed the max_payload of the nats server
+func WithMaxBodySize(n int64) Option {
+	return func(p *config) {
+		p.maxBody = n
+	}
+}
+
+// WithRouteMaxBodySize specifies the maximum size of a request body for subjects matching the pattern, using nats
+// wildcards e.g. api.uploads.>, overriding WithMaxBodySize.  The first matching pattern applies
+func WithRouteMaxBodySize(pattern string, n int64) Option {
+	return func(p *config) {
+		p.bodyLimits = append(p.bodyLimits, bodyLimit{pattern: pattern, limit: n})
+	}
+}
+
+// WithCodec specifies the Codec a Gateway encodes requests with; defaults to ```nats_proxy.ProtobufCodec```.  Routers
+// detect the Codec of each request and reply with the same one, so need not be configured
+func WithCodec(codec Codec) Option {
+	return func(p *config) {
+		p.codec = codec
+	}
+}
+
+// WithTrustedProxies specifies the load balancers and proxies in front of the Gateway, as ip addresses or cidr ranges
+// e.g. 10.0.0.0/8.  X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host are only honored on requests from trusted
+// proxies when describing the client to services
+func WithTrustedProxies(proxies ...string) Option {
+	return func(p *config) {
+		p.trustedProxies = append(p.trustedProxies, proxies...)
+	}
+}
+
+// WithCompression enables compression of Message bodies crossing nats using the encodings provided, in order of
+// preference e.g. WithCompression(EncodingZstd, EncodingGzip).  A Gateway advertises the encodings it accepts for
+// replies; a Router compresses replies with the first of its encodings that the request accepts.  Services see
+// uncompressed bodies either way
+func WithCompression(encodings ...string) Option {
+	return func(p *config) {
+		p.compression.encodings = encodings
+	}
+}
+
+// WithCompressionThreshold specifies the smallest body that will be compressed; defaults to
+// ```nats_proxy.DefaultCompressionThreshold```
+func WithCompressionThreshold(n int) Option {
+	return func(p *config) {
+		p.compression.threshold = n
+	}
+}
+
+// WithRequestCompression additionally compresses request bodies sent by the Gateway with the first encoding provided
+// to WithCompression.  Every Router must support the encoding i.e. be running a version with compression support
+func WithRequestCompression() Option {
+	return func(p *config) {
+		p.compression.requests = true
+	}
+}
+
+// WithResponseCompression compresses replies to http clients with brotli or gzip according to their Accept-Encoding,
+// so services need not.  Replies the service has already encoded are passed through untouched
+func WithResponseCompression(c ResponseCompression) Option {
+	return func(p *config) {
+		p.encoding = &c
+	}
+}
+
+// WithCORS enables cross-origin requests from browsers.  Preflight requests are answered by the Gateway without
+// being sent over nats and replies are decorated with the appropriate Access-Control headers
+func WithCORS(cors CORS) Option {
+	return func(p *config) {
+		p.cors = &cors
+	}
+}
+
+// WithSigningKey specifies the key a Gateway signs each Message with, covering its subject, method, headers, cookies
+// and Identity, so Routers configured WithVerifyKeys can trust them
+func WithSigningKey(key Key) Option {
+	return func(p *config) {
+		p.signingKey = &key
+	}
+}
+
+// WithSignatureTTL specifies how long a signed Message remains valid; defaults to
+// ```nats_proxy.DefaultSignatureTTL```
+func WithSignatureTTL(d time.Duration) Option {
+	return func(p *config) {
+		p.signatureTTL = d
+	}
+}
+
+// WithVerifyKeys specifies the keys a Router accepts signatures from; once set, messages that are unsigned, expired or
+// signed by any other key are rejected with 401 Unauthorized.  To rotate keys, add the new key to every Router, switch
+// the Gateway to sign with it, then remove the old key
+func WithVerifyKeys(keys ...Key) Option {
+	return func(p *config) {
+		if p.verifyKeys == nil {
+			p.verifyKeys = map[string]Key{}
+		}
+		for _, key := range keys {
+			p.verifyKeys[key.ID] = key
+		}
+	}
+}
+
 // WithNopHandler provides an nop handler useful for testing; the content submitted will be echoed back
 func WithNopHandler() Option {
 	return func(p *config) {
@@ -132,17 +401,19 @@ func readConfig(opts ...Option) (*config, error) {
 		subject:        DefaultSubject,
 		queue:          DefaultQueue,
 		timeout:        DefaultTimeout,
+		drainTimeout:   DefaultDrainTimeout,
+		logLevel:       LevelOff,
+		controlSubject: DefaultControlSubject,
+		heartbeat:      DefaultHeartbeatInterval,
+		signatureTTL:   DefaultSignatureTTL,
+		compression:    compression{threshold: DefaultCompressionThreshold},
+		codec:          ProtobufCodec,
 		onError:        onError,
 		returnNotFound: true,
-		headers: map[string]struct{}{
-			"Content-Disposition": {},
-			"Content-Encoding":    {},
-			"Content-Length":      {},
-			"Content-Range":       {},
-			"Content-Type":        {},
-			"Set-Cookie":          {},
+		headerRules: []HeaderRule{
+			{Effect: Allow, Headers: DefaultHeaders, Direction: DirectionRequest},
 		},
-		cookies: map[string]struct{}{},
+		instrumented: map[string]Instrumented{},
 	}
 
 	for _, opt := range opts {
@@ -154,11 +425,14 @@ func readConfig(opts ...Option) (*config, error) {
 			c.url = nats.DefaultURL
 		}
 
-		v, err := nats.Connect(c.url)
+		connClosed := make(chan struct{})
+		v, err := nats.Connect(c.url, nats.ClosedHandler(func(*nats.Conn) { close(connClosed) }))
 		if err != nil {
 			return nil, errors.Wrapf(err, "Unable to connect to nats url, %v", c.url)
 		}
 		c.nc = v
+		c.ownsConn = true
+		c.connClosed = connClosed
 	}
 
 	return c, nil
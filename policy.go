@@ -0,0 +1,244 @@
+package nats_proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// Effect is the outcome of a matching Rule
+type Effect string
+
+const (
+	// Allow permits requests matched by the Rule
+	Allow Effect = "allow"
+
+	// Deny rejects requests matched by the Rule with 403 Forbidden
+	Deny Effect = "deny"
+)
+
+// Rule grants or denies callers access to subjects.  A Rule matches a request when the subject matches one of
+// Subjects, using NATS wildcard semantics e.g. api.admin.> or api.*.users, the method is one of Methods, and the
+// Identity of the caller carries every one of Claims.  Empty Methods match any method; empty Claims match any caller,
+// including anonymous callers unless Authenticated is set
+type Rule struct {
+	Effect        Effect            `json:"effect"`
+	Subjects      []string          `json:"subjects"`
+	Methods       []string          `json:"methods,omitempty"`
+	Claims        map[string]string `json:"claims,omitempty"`
+	Authenticated bool              `json:"authenticated,omitempty"`
+}
+
+// subjectMatches returns true if the subject matches the pattern; * matches a single token and > matches one or more
+// trailing tokens
+func subjectMatches(pattern, subject string) bool {
+	patterns := strings.Split(pattern, ".")
+	tokens := strings.Split(subject, ".")
+
+	for i, p := range patterns {
+		if p == ">" {
+			return len(tokens) > i
+		}
+		if i >= len(tokens) || (p != "*" && p != tokens[i]) {
+			return false
+		}
+	}
+	return len(patterns) == len(tokens)
+}
+
+// claimMatches returns true if the claim equals the value or, for claims that are json arrays e.g. roles, contains it.
+// The sub claim falls back to the subject of the Identity so rules may name api key owners and basic auth users
+func claimMatches(identity *Identity, name, value string) bool {
+	claim, ok := identity.Claims[name]
+	if !ok && name == "sub" {
+		claim, ok = identity.Subject, identity.Subject != ""
+	}
+	if !ok {
+		return false
+	}
+	if value == "*" || claim == value {
+		return true
+	}
+
+	var values []interface{}
+	if err := json.Unmarshal([]byte(claim), &values); err == nil {
+		for _, v := range values {
+			if claimValue(v) == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r Rule) matches(subject, method string, identity *Identity) bool {
+	if (r.Authenticated || len(r.Claims) > 0) && identity == nil {
+		return false
+	}
+
+	if len(r.Methods) > 0 {
+		found := false
+		for _, m := range r.Methods {
+			if strings.EqualFold(m, method) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	found := false
+	for _, pattern := range r.Subjects {
+		if subjectMatches(pattern, subject) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return false
+	}
+
+	for name, value := range r.Claims {
+		if !claimMatches(identity, name, value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ParseRules parses a json array of Rules
+func ParseRules(data []byte) ([]Rule, error) {
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, errors.Wrap(err, "invalid policy")
+	}
+
+	for i, rule := range rules {
+		if rule.Effect != Allow && rule.Effect != Deny {
+			return nil, errors.Errorf("invalid policy, rule %v: effect must be allow or deny", i)
+		}
+		if len(rule.Subjects) == 0 {
+			return nil, errors.Errorf("invalid policy, rule %v: at least one subject required", i)
+		}
+	}
+
+	return rules, nil
+}
+
+// ReadRules reads a json array of Rules from a file
+func ReadRules(filename string) ([]Rule, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRules(data)
+}
+
+// PolicyOption configures a Policy
+type PolicyOption func(*Policy)
+
+// PolicyDryRun logs requests the Policy would deny rather than denying them; useful to audit a new policy against
+// live traffic before enforcing it.  Decisions are logged at LevelInfo through the Gateway's log level
+func PolicyDryRun() PolicyOption {
+	return func(p *Policy) {
+		p.dryRun = true
+	}
+}
+
+// PolicyStats reports the Policy's activity
+type PolicyStats struct {
+	Allowed   uint64 `json:"allowed"`
+	Denied    uint64 `json:"denied"`
+	WouldDeny uint64 `json:"would_deny"`
+	DryRun    bool   `json:"dry_run"`
+}
+
+// Policy authorizes requests against an ordered list of Rules before anything is published to nats.  The first Rule
+// that matches decides; requests matched by no Rule are denied.  Register the Policy after Auth so the Identity of the
+// caller is known
+type Policy struct {
+	allowed   uint64 // accessed atomically
+	denied    uint64 // accessed atomically
+	wouldDeny uint64 // accessed atomically
+	rules     []Rule
+	dryRun    bool
+}
+
+// NewPolicy returns a new Policy; register it with WithInstrumented to report its stats on the admin api
+func NewPolicy(rules []Rule, opts ...PolicyOption) *Policy {
+	p := &Policy{
+		rules: rules,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// Stats returns a snapshot of the Policy's activity
+func (p *Policy) Stats() interface{} {
+	return PolicyStats{
+		Allowed:   atomic.LoadUint64(&p.allowed),
+		Denied:    atomic.LoadUint64(&p.denied),
+		WouldDeny: atomic.LoadUint64(&p.wouldDeny),
+		DryRun:    p.dryRun,
+	}
+}
+
+// evaluate returns the Effect of the first matching Rule and its index; -1 if no Rule matched
+func (p *Policy) evaluate(subject, method string, identity *Identity) (Effect, int) {
+	for i, rule := range p.rules {
+		if rule.matches(subject, method, identity) {
+			return rule.Effect, i
+		}
+	}
+	return Deny, -1
+}
+
+// Filter returns the Filter that enforces the Policy
+func (p *Policy) Filter() Filter {
+	return func(h Handler) Handler {
+		return func(ctx context.Context, subject string, message *Message) (*Message, error) {
+			if message == nil {
+				return h(ctx, subject, message)
+			}
+
+			effect, index := p.evaluate(subject, message.Method, message.Identity)
+			if effect == Allow {
+				atomic.AddUint64(&p.allowed, 1)
+				return h(ctx, subject, message)
+			}
+
+			caller := "anonymous"
+			if message.Identity != nil {
+				caller = message.Identity.Method + ":" + message.Identity.Subject
+			}
+
+			if p.dryRun {
+				atomic.AddUint64(&p.wouldDeny, 1)
+				logf(ctx, LevelInfo, "policy would deny %v %v for %v (rule %v)", message.Method, subject, caller, index)
+				return h(ctx, subject, message)
+			}
+
+			atomic.AddUint64(&p.denied, 1)
+			logf(ctx, LevelInfo, "policy denied %v %v for %v (rule %v)", message.Method, subject, caller, index)
+			return &Message{
+				Status: http.StatusForbidden,
+				Header: map[string]string{
+					"Content-Type": "text/plain; charset=utf-8",
+				},
+				Body: []byte(http.StatusText(http.StatusForbidden) + "\n"),
+			}, nil
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package nats_proxy
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubjectMatches(t *testing.T) {
+	testCases := []struct {
+		Pattern string
+		Subject string
+		Match   bool
+	}{
+		{Pattern: "api.foo", Subject: "api.foo", Match: true},
+		{Pattern: "api.foo", Subject: "api.foo.bar"},
+		{Pattern: "api.*", Subject: "api.foo", Match: true},
+		{Pattern: "api.*", Subject: "api.foo.bar"},
+		{Pattern: "api.*.bar", Subject: "api.foo.bar", Match: true},
+		{Pattern: "api.>", Subject: "api.foo.bar", Match: true},
+		{Pattern: "api.>", Subject: "api"},
+		{Pattern: ">", Subject: "api", Match: true},
+		{Pattern: "api.{id}", Subject: "api.123"},
+		{Pattern: "api.{id}", Subject: "api.{id}", Match: true},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.Match, subjectMatches(tc.Pattern, tc.Subject), "%v %v", tc.Pattern, tc.Subject)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	rules, err := ParseRules([]byte(`[
+		{"effect": "allow", "subjects": ["api.admin.>"], "claims": {"roles": "admin"}},
+		{"effect": "deny", "subjects": ["api.admin.>"]},
+		{"effect": "allow", "subjects": ["api.users.*"], "methods": ["GET"]},
+		{"effect": "allow", "subjects": ["api.users.*"], "authenticated": true}
+	]`))
+	assert.Nil(t, err)
+
+	h := func(ctx context.Context, subject string, message *Message) (*Message, error) {
+		return &Message{Status: http.StatusOK}, nil
+	}
+
+	testCases := map[string]struct {
+		Method   string
+		Path     string
+		Identity *Identity
+		Status   int
+	}{
+		"admin": {
+			Method:   "DELETE",
+			Path:     "/admin/users/1",
+			Identity: &Identity{Subject: "a", Claims: map[string]string{"roles": `["user","admin"]`}},
+			Status:   http.StatusOK,
+		},
+		"not admin": {
+			Method:   "GET",
+			Path:     "/admin/users/1",
+			Identity: &Identity{Subject: "b", Claims: map[string]string{"roles": `["user"]`}},
+			Status:   http.StatusForbidden,
+		},
+		"anonymous read": {
+			Method: "GET",
+			Path:   "/users/1",
+			Status: http.StatusOK,
+		},
+		"anonymous write": {
+			Method: "PUT",
+			Path:   "/users/1",
+			Status: http.StatusForbidden,
+		},
+		"authenticated write": {
+			Method:   "PUT",
+			Path:     "/users/1",
+			Identity: &Identity{Subject: "b"},
+			Status:   http.StatusOK,
+		},
+		"no rule": {
+			Method:   "GET",
+			Path:     "/orders/1",
+			Identity: &Identity{Subject: "a", Claims: map[string]string{"roles": `["admin"]`}},
+			Status:   http.StatusForbidden,
+		},
+	}
+
+	for _, dryRun := range []bool{false, true} {
+		var opts []PolicyOption
+		if dryRun {
+			opts = append(opts, PolicyDryRun())
+		}
+		policy := NewPolicy(rules, opts...)
+
+		var identity *Identity
+		setIdentity := func(h Handler) Handler {
+			return func(ctx context.Context, subject string, message *Message) (*Message, error) {
+				message.Identity = identity
+				return h(ctx, subject, message)
+			}
+		}
+
+		gw, err := NewGateway(
+			WithHandler(h),
+			WithFilters(setIdentity),
+			WithInstrumented("policy", policy),
+		)
+		assert.Nil(t, err)
+
+		for label, tc := range testCases {
+			identity = tc.Identity
+			req := httptest.NewRequest(tc.Method, "http://localhost"+tc.Path, nil)
+			w := httptest.NewRecorder()
+			gw.ServeHTTP(w, req)
+
+			if dryRun {
+				assert.Equal(t, http.StatusOK, w.Code, label)
+			} else {
+				assert.Equal(t, tc.Status, w.Code, label)
+			}
+		}
+
+		if dryRun {
+			assert.Equal(t, PolicyStats{Allowed: 3, WouldDeny: 3, DryRun: true}, policy.Stats())
+		} else {
+			assert.Equal(t, PolicyStats{Allowed: 3, Denied: 3}, policy.Stats())
+		}
+		gw.Close()
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	_, err := ParseRules([]byte(`[{"effect": "maybe", "subjects": ["api.>"]}]`))
+	assert.NotNil(t, err)
+
+	_, err = ParseRules([]byte(`[{"effect": "allow"}]`))
+	assert.NotNil(t, err)
+}
+
+func TestPolicyLogging(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	rules, err := ParseRules([]byte(`[{"effect": "allow", "subjects": ["api.public.>"]}]`))
+	assert.Nil(t, err)
+	policy := NewPolicy(rules, PolicyDryRun())
+
+	h := func(ctx context.Context, subject string, message *Message) (*Message, error) {
+		return &Message{Status: http.StatusOK}, nil
+	}
+	gw, err := NewGateway(WithHandler(h), WithInstrumented("policy", policy))
+	assert.Nil(t, err)
+	defer gw.Close()
+
+	// quiet at the default log level
+	gw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost/private", nil))
+	assert.Equal(t, "", buf.String())
+
+	gw.SetLogLevel(LevelInfo)
+	gw.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://localhost/private", nil))
+	assert.Contains(t, buf.String(), "INFO: policy would deny GET api.private for anonymous")
+}
@@ -0,0 +1,386 @@
+package nats_proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RateLimit allows Requests per Per with bursts of up to Burst requests; Burst defaults to Requests
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+	Burst    int
+}
+
+func (r RateLimit) burst() float64 {
+	if r.Burst > 0 {
+		return float64(r.Burst)
+	}
+	return float64(r.Requests)
+}
+
+// interval returns the time required to earn a single token
+func (r RateLimit) interval() time.Duration {
+	return r.Per / time.Duration(r.Requests)
+}
+
+// RateResult is the outcome of taking a token from a bucket
+type RateResult struct {
+	Allowed    bool
+	Remaining  int
+	Reset      time.Duration // time until the bucket is full
+	RetryAfter time.Duration // time until a token is available; 0 if allowed
+}
+
+// bucket is the state of a single token bucket
+type bucket struct {
+	Tokens  float64 `json:"tokens"`
+	Updated int64   `json:"updated"` // unix nanos
+}
+
+// take refills the bucket for the time elapsed and attempts to take a single token
+func (b *bucket) take(limit RateLimit, now time.Time) RateResult {
+	capacity := limit.burst()
+	interval := limit.interval()
+
+	if b.Updated == 0 {
+		b.Tokens = capacity
+	} else if elapsed := now.UnixNano() - b.Updated; elapsed > 0 {
+		b.Tokens = math.Min(capacity, b.Tokens+float64(elapsed)/float64(interval))
+	}
+	b.Updated = now.UnixNano()
+
+	result := RateResult{}
+	if b.Tokens >= 1 {
+		b.Tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.Tokens) * float64(interval))
+	}
+	result.Remaining = int(b.Tokens)
+	result.Reset = time.Duration((capacity - b.Tokens) * float64(interval))
+
+	return result
+}
+
+// RateStore holds the token buckets for a RateLimiter
+type RateStore interface {
+	Take(ctx context.Context, key string, limit RateLimit, now time.Time) (RateResult, error)
+}
+
+type memoryRateStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// NewMemoryRateStore returns a RateStore local to this process
+func NewMemoryRateStore() RateStore {
+	return &memoryRateStore{
+		buckets: map[string]*bucket{},
+	}
+}
+
+func (m *memoryRateStore) Take(_ context.Context, key string, limit RateLimit, now time.Time) (RateResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.buckets[key]
+	if !ok {
+		if len(m.buckets) >= maxIdleKeys {
+			m.sweep(limit, now)
+		}
+		b = &bucket{}
+		m.buckets[key] = b
+	}
+
+	return b.take(limit, now), nil
+}
+
+// sweep discards buckets that have refilled completely and are therefore indistinguishable from new buckets
+func (m *memoryRateStore) sweep(limit RateLimit, now time.Time) {
+	full := time.Duration(limit.burst() * float64(limit.interval()))
+	for key, b := range m.buckets {
+		if now.Sub(time.Unix(0, b.Updated)) > full {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+// maxRateConflicts bounds the number of times a shared bucket update is retried when another replica wins the race
+const maxRateConflicts = 10
+
+type kvRateStore struct {
+	kv KeyValue
+}
+
+// NewKVRateStore returns a RateStore that keeps its buckets in a KeyValue, such as NewKeyValue, so limits are enforced
+// across gateway replicas
+func NewKVRateStore(kv KeyValue) RateStore {
+	return &kvRateStore{kv: kv}
+}
+
+func (k *kvRateStore) Take(ctx context.Context, key string, limit RateLimit, now time.Time) (RateResult, error) {
+	key = base64.RawURLEncoding.EncodeToString([]byte(key)) // keys must be valid subject tokens
+
+	for i := 0; ; i++ {
+		b := bucket{}
+		data, revision, err := k.kv.Get(ctx, key)
+		switch {
+		case err == ErrKeyNotFound:
+			revision = 0
+		case err != nil:
+			return RateResult{}, err
+		default:
+			if err := json.Unmarshal(data, &b); err != nil {
+				return RateResult{}, err
+			}
+		}
+
+		result := b.take(limit, now)
+		if data, err = json.Marshal(b); err != nil {
+			return RateResult{}, err
+		}
+
+		if revision == 0 {
+			_, err = k.kv.Create(ctx, key, data)
+		} else {
+			_, err = k.kv.Update(ctx, key, data, revision)
+		}
+		if err == nil {
+			return result, nil
+		}
+		if err != ErrWrongRevision || i >= maxRateConflicts {
+			return RateResult{}, err
+		}
+	}
+}
+
+// RateKey identifies the client a request is attributed to; requests with an empty key are not limited
+type RateKey func(ctx context.Context, subject string, message *Message) string
+
+// ByClientIP attributes requests to the ip address of the client, as seen through any trusted proxies
+func ByClientIP() RateKey {
+	return func(ctx context.Context, _ string, message *Message) string {
+		addr := message.GetClient().GetRemoteAddr()
+		if addr == "" {
+			req, ok := HTTPRequest(ctx)
+			if !ok {
+				return ""
+			}
+			addr = req.RemoteAddr
+		}
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			return host
+		}
+		return addr
+	}
+}
+
+// ByHeader attributes requests to the value of the specified http header e.g. an api key; the header need not be
+// passed across nats
+func ByHeader(name string) RateKey {
+	return func(ctx context.Context, _ string, message *Message) string {
+		if req, ok := HTTPRequest(ctx); ok {
+			return req.Header.Get(name)
+		}
+		return message.Header[http.CanonicalHeaderKey(name)]
+	}
+}
+
+// ByCookie attributes requests to the value of the specified cookie
+func ByCookie(name string) RateKey {
+	return func(ctx context.Context, _ string, message *Message) string {
+		if req, ok := HTTPRequest(ctx); ok {
+			if cookie, err := req.Cookie(name); err == nil {
+				return cookie.Value
+			}
+			return ""
+		}
+		if cookie, ok := message.Cookies[name]; ok {
+			return cookie.Value
+		}
+		return ""
+	}
+}
+
+// BySubject attributes requests to the first n tokens of their subject, limiting each service as a whole
+func BySubject(n int) RateKey {
+	prefix := SubjectPrefix(n)
+	return func(_ context.Context, subject string, _ *Message) string {
+		return prefix(subject)
+	}
+}
+
+// FirstKey uses the first non-empty key e.g. FirstKey(ByHeader("X-Api-Key"), ByClientIP())
+func FirstKey(keys ...RateKey) RateKey {
+	return func(ctx context.Context, subject string, message *Message) string {
+		for _, key := range keys {
+			if v := key(ctx, subject, message); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+}
+
+// RateLimitOption configures a RateLimiter
+type RateLimitOption func(*RateLimiter)
+
+// RateLimitKey specifies how requests are attributed to clients; defaults to ByClientIP
+func RateLimitKey(key RateKey) RateLimitOption {
+	return func(r *RateLimiter) {
+		r.key = key
+	}
+}
+
+// RateLimitStore specifies where token buckets are kept; defaults to NewMemoryRateStore
+func RateLimitStore(store RateStore) RateLimitOption {
+	return func(r *RateLimiter) {
+		r.store = store
+	}
+}
+
+// RateLimitStats reports the RateLimiter's activity
+type RateLimitStats struct {
+	Allowed uint64 `json:"allowed"`
+	Limited uint64 `json:"limited"`
+	Errors  uint64 `json:"errors"`
+}
+
+// RateLimiter rejects requests with 429 Too Many Requests once a client exceeds its limit, before anything is
+// published to nats.  Responses carry the RateLimit-Limit, RateLimit-Policy, RateLimit-Remaining and RateLimit-Reset
+// headers of the IETF RateLimit header fields draft and, when rejected, Retry-After.  If the store fails, requests are
+// allowed
+type RateLimiter struct {
+	allowed uint64 // accessed atomically
+	limited uint64 // accessed atomically
+	errors  uint64 // accessed atomically
+	limit   RateLimit
+	key     RateKey
+	store   RateStore
+}
+
+// NewRateLimiter returns a new RateLimiter; register it with WithInstrumented to report its stats on the admin api
+func NewRateLimiter(limit RateLimit, opts ...RateLimitOption) *RateLimiter {
+	r := &RateLimiter{
+		limit: limit,
+		key:   ByClientIP(),
+		store: NewMemoryRateStore(),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Stats returns a snapshot of the RateLimiter's activity
+func (r *RateLimiter) Stats() interface{} {
+	return RateLimitStats{
+		Allowed: atomic.LoadUint64(&r.allowed),
+		Limited: atomic.LoadUint64(&r.limited),
+		Errors:  atomic.LoadUint64(&r.errors),
+	}
+}
+
+// Filter returns the Filter that enforces the limit
+func (r *RateLimiter) Filter() Filter {
+	return func(h Handler) Handler {
+		return func(ctx context.Context, subject string, message *Message) (*Message, error) {
+			if r.limit.Requests <= 0 || r.limit.Per <= 0 {
+				return h(ctx, subject, message)
+			}
+
+			key := r.key(ctx, subject, message)
+			if key == "" {
+				return h(ctx, subject, message)
+			}
+
+			result, err := r.store.Take(ctx, key, r.limit, time.Now())
+			if err != nil {
+				atomic.AddUint64(&r.errors, 1)
+				log.Printf("Unable to apply rate limit, %v\n", err)
+				return h(ctx, subject, message)
+			}
+
+			if !result.Allowed {
+				atomic.AddUint64(&r.limited, 1)
+				out := &Message{
+					Status: http.StatusTooManyRequests,
+					Header: map[string]string{
+						"Content-Type": "text/plain; charset=utf-8",
+						"Retry-After":  strconv.Itoa(seconds(result.RetryAfter)),
+					},
+					Body: []byte(http.StatusText(http.StatusTooManyRequests) + "\n"),
+				}
+				r.decorate(out, result)
+				return out, nil
+			}
+
+			atomic.AddUint64(&r.allowed, 1)
+			out, err := h(ctx, subject, message)
+			if out != nil {
+				r.decorate(out, result)
+			}
+			return out, err
+		}
+	}
+}
+
+func (r *RateLimiter) decorate(out *Message, result RateResult) {
+	limit := strconv.Itoa(int(r.limit.burst()))
+	setHeader(out, "Ratelimit-Limit", limit)
+	setHeader(out, "Ratelimit-Policy", limit+";w="+strconv.Itoa(seconds(r.limit.Per)))
+	setHeader(out, "Ratelimit-Remaining", strconv.Itoa(result.Remaining))
+	setHeader(out, "Ratelimit-Reset", strconv.Itoa(seconds(result.Reset)))
+}
+
+// seconds rounds the duration up to whole seconds
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// ParseRateLimit parses a limit of the form requests/duration[,burst] e.g. 100/1m or 10/1s,20
+func ParseRateLimit(s string) (RateLimit, error) {
+	limit := RateLimit{}
+
+	if segments := strings.SplitN(s, ",", 2); len(segments) == 2 {
+		burst, err := strconv.Atoi(strings.TrimSpace(segments[1]))
+		if err != nil {
+			return RateLimit{}, err
+		}
+		limit.Burst = burst
+		s = segments[0]
+	}
+
+	segments := strings.SplitN(s, "/", 2)
+	if len(segments) != 2 {
+		return RateLimit{}, errors.Errorf("invalid rate limit, %v; expected requests/duration[,burst] e.g. 100/1m", s)
+	}
+
+	requests, err := strconv.Atoi(strings.TrimSpace(segments[0]))
+	if err != nil || requests <= 0 {
+		return RateLimit{}, errors.Errorf("invalid rate limit, %v; expected requests/duration[,burst] e.g. 100/1m", s)
+	}
+	per, err := time.ParseDuration(strings.TrimSpace(segments[1]))
+	if err != nil || per <= 0 {
+		return RateLimit{}, errors.Errorf("invalid rate limit, %v; expected requests/duration[,burst] e.g. 100/1m", s)
+	}
+
+	limit.Requests = requests
+	limit.Per = per
+	return limit, nil
+}
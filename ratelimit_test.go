@@ -0,0 +1,125 @@
+package nats_proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryKV is a KeyValue for testing
+type memoryKV struct {
+	mu       sync.Mutex
+	values   map[string][]byte
+	revision map[string]uint64
+	sequence uint64
+}
+
+func newMemoryKV() *memoryKV {
+	return &memoryKV{
+		values:   map[string][]byte{},
+		revision: map[string]uint64{},
+	}
+}
+
+func (m *memoryKV) Get(_ context.Context, key string) ([]byte, uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	value, ok := m.values[key]
+	if !ok {
+		return nil, 0, ErrKeyNotFound
+	}
+	return value, m.revision[key], nil
+}
+
+func (m *memoryKV) put(key string, value []byte) uint64 {
+	m.sequence++
+	m.values[key] = value
+	m.revision[key] = m.sequence
+	return m.sequence
+}
+
+func (m *memoryKV) Put(_ context.Context, key string, value []byte) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.put(key, value), nil
+}
+
+func (m *memoryKV) Create(_ context.Context, key string, value []byte) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.values[key]; ok {
+		return 0, ErrWrongRevision
+	}
+	return m.put(key, value), nil
+}
+
+func (m *memoryKV) Update(_ context.Context, key string, value []byte, revision uint64) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.revision[key] != revision {
+		return 0, ErrWrongRevision
+	}
+	return m.put(key, value), nil
+}
+
+func TestRateLimiter(t *testing.T) {
+	stores := map[string]RateStore{
+		"memory": NewMemoryRateStore(),
+		"kv":     NewKVRateStore(newMemoryKV()),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			limiter := NewRateLimiter(RateLimit{Requests: 2, Per: time.Minute}, RateLimitStore(store))
+			gw, err := NewGateway(
+				WithNopHandler(),
+				WithInstrumented("ratelimit", limiter),
+			)
+			assert.Nil(t, err)
+			defer gw.Close()
+
+			call := func(remoteAddr string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest("GET", "http://localhost/foo", nil)
+				req.RemoteAddr = remoteAddr
+				w := httptest.NewRecorder()
+				gw.ServeHTTP(w, req)
+				return w
+			}
+
+			w := call("10.0.0.1:1234")
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+			assert.Equal(t, "2;w=60", w.Header().Get("RateLimit-Policy"))
+			assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+
+			assert.Equal(t, http.StatusOK, call("10.0.0.1:1235").Code)
+
+			w = call("10.0.0.1:1236")
+			assert.Equal(t, http.StatusTooManyRequests, w.Code)
+			assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+			assert.Equal(t, "30", w.Header().Get("Retry-After"))
+
+			// other clients are unaffected
+			assert.Equal(t, http.StatusOK, call("10.0.0.2:1234").Code)
+
+			assert.Equal(t, RateLimitStats{Allowed: 3, Limited: 1}, limiter.Stats())
+		})
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	limit, err := ParseRateLimit("10/1s,20")
+	assert.Nil(t, err)
+	assert.Equal(t, RateLimit{Requests: 10, Per: time.Second, Burst: 20}, limit)
+
+	_, err = ParseRateLimit("10")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,220 @@
+package nats_proxy
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultRetryAttempts specifies the maximum number of attempts, including the first, made for a request
+	DefaultRetryAttempts = 3
+
+	// DefaultRetryBackoff specifies the initial backoff between attempts; doubled after each attempt
+	DefaultRetryBackoff = time.Millisecond * 50
+
+	// DefaultRetryMaxBackoff specifies the upper bound of the backoff between attempts
+	DefaultRetryMaxBackoff = time.Second
+
+	// RetryAttemptsHeader is set on the response to the number of attempts made
+	RetryAttemptsHeader = "X-Retry-Attempts"
+
+	// IdempotencyKeyHeader marks a request as safe to retry regardless of method.  Note the header must be passed
+	// across nats via WithHeaders to be visible to the Retrier
+	IdempotencyKeyHeader = "Idempotency-Key"
+)
+
+// RetryOption configures a Retrier
+type RetryOption func(*Retrier)
+
+// RetryAttempts specifies the maximum number of attempts, including the first; defaults to
+// ```nats_proxy.DefaultRetryAttempts```
+func RetryAttempts(n int) RetryOption {
+	return func(r *Retrier) {
+		if n > 0 {
+			r.attempts = n
+		}
+	}
+}
+
+// RetryBackoff specifies the initial and maximum backoff between attempts; defaults to
+// ```nats_proxy.DefaultRetryBackoff``` and ```nats_proxy.DefaultRetryMaxBackoff```
+func RetryBackoff(initial, max time.Duration) RetryOption {
+	return func(r *Retrier) {
+		r.backoff = initial
+		r.maxBackoff = max
+	}
+}
+
+// RetryAttemptTimeout specifies how long a single attempt may take.  By default, the time remaining in the request is
+// split evenly across the remaining attempts
+func RetryAttemptTimeout(d time.Duration) RetryOption {
+	return func(r *Retrier) {
+		r.attemptTimeout = d
+	}
+}
+
+// RetryStats reports the Retrier's activity
+type RetryStats struct {
+	Requests  uint64 `json:"requests"`
+	Retries   uint64 `json:"retries"`
+	Exhausted uint64 `json:"exhausted"`
+}
+
+// Retrier retries idempotent requests that time out or find no responders, backing off exponentially with jitter
+// between attempts.  All attempts are bounded by the overall deadline of the request e.g. WithTimeout
+type Retrier struct {
+	requests       uint64 // accessed atomically
+	retries        uint64 // accessed atomically
+	exhausted      uint64 // accessed atomically
+	attempts       int
+	backoff        time.Duration
+	maxBackoff     time.Duration
+	attemptTimeout time.Duration
+	mu             sync.Mutex
+	random         *rand.Rand
+}
+
+// NewRetrier returns a new Retrier; register it with WithInstrumented to report its stats on the admin api
+func NewRetrier(opts ...RetryOption) *Retrier {
+	r := &Retrier{
+		attempts:   DefaultRetryAttempts,
+		backoff:    DefaultRetryBackoff,
+		maxBackoff: DefaultRetryMaxBackoff,
+		random:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Retry returns a Filter that retries idempotent requests; shorthand for NewRetrier(opts...).Filter()
+func Retry(opts ...RetryOption) Filter {
+	return NewRetrier(opts...).Filter()
+}
+
+// Stats returns a snapshot of the Retrier's activity
+func (r *Retrier) Stats() interface{} {
+	return RetryStats{
+		Requests:  atomic.LoadUint64(&r.requests),
+		Retries:   atomic.LoadUint64(&r.retries),
+		Exhausted: atomic.LoadUint64(&r.exhausted),
+	}
+}
+
+// Filter returns the Filter that performs the retries
+func (r *Retrier) Filter() Filter {
+	return func(h Handler) Handler {
+		return func(ctx context.Context, subject string, message *Message) (*Message, error) {
+			if !isIdempotent(message) {
+				return h(ctx, subject, message)
+			}
+
+			atomic.AddUint64(&r.requests, 1)
+
+			for attempt := 1; ; attempt++ {
+				out, err := r.attempt(ctx, h, attempt, subject, message)
+				if err == nil {
+					if out != nil {
+						setHeader(out, RetryAttemptsHeader, strconv.Itoa(attempt))
+					}
+					return out, nil
+				}
+
+				if !isRetryable(ctx, err) {
+					return nil, err
+				}
+
+				if attempt >= r.attempts {
+					atomic.AddUint64(&r.exhausted, 1)
+					return nil, err
+				}
+
+				delay := r.delay(attempt)
+				if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+					atomic.AddUint64(&r.exhausted, 1)
+					return nil, err
+				}
+
+				select {
+				case <-ctx.Done():
+					return nil, err
+				case <-time.After(delay):
+				}
+
+				atomic.AddUint64(&r.retries, 1)
+			}
+		}
+	}
+}
+
+func (r *Retrier) attempt(ctx context.Context, h Handler, attempt int, subject string, message *Message) (*Message, error) {
+	timeout := r.attemptTimeout
+	if timeout <= 0 {
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = time.Until(deadline) / time.Duration(r.attempts-attempt+1)
+		}
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return h(ctx, subject, message)
+}
+
+// delay returns a random duration up to the exponential backoff for the attempt ("full jitter")
+func (r *Retrier) delay(attempt int) time.Duration {
+	backoff := r.backoff << uint(attempt-1)
+	if backoff <= 0 || backoff > r.maxBackoff {
+		backoff = r.maxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return time.Duration(r.random.Int63n(int64(backoff)))
+}
+
+func isIdempotent(message *Message) bool {
+	if message == nil {
+		return false
+	}
+
+	switch message.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return message.Header[IdempotencyKeyHeader] != ""
+	}
+}
+
+// isRetryable returns true if the attempt timed out or found no responders while the request itself is still live
+func isRetryable(ctx context.Context, err error) bool {
+	return ctx.Err() == nil && isUnavailable(err)
+}
+
+// isUnavailable returns true if the request timed out or found no responders, rather than failing because of the
+// request itself
+func isUnavailable(err error) bool {
+	switch errors.Cause(err) {
+	case context.DeadlineExceeded, nats.ErrTimeout, nats.ErrNoResponders:
+		return true
+	default:
+		return false
+	}
+}
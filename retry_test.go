@@ -0,0 +1,84 @@
+package nats_proxy
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+func failing(n int, err error) (Handler, *int) {
+	calls := 0
+	return func(ctx context.Context, subject string, message *Message) (*Message, error) {
+		calls++
+		if calls <= n {
+			return nil, err
+		}
+		return &Message{Status: http.StatusOK}, nil
+	}, &calls
+}
+
+func TestRetry(t *testing.T) {
+	t.Run("retries idempotent requests", func(t *testing.T) {
+		r := NewRetrier(RetryBackoff(time.Millisecond, time.Millisecond*5))
+		h, calls := failing(2, nats.ErrNoResponders)
+
+		out, err := r.Filter()(h).Apply(context.Background(), "api", &Message{Method: http.MethodGet})
+		assert.Nil(t, err)
+		assert.Equal(t, 3, *calls)
+		assert.Equal(t, "3", out.Header[RetryAttemptsHeader])
+		assert.Equal(t, RetryStats{Requests: 1, Retries: 2}, r.Stats())
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		r := NewRetrier(RetryAttempts(2), RetryBackoff(time.Millisecond, time.Millisecond))
+		h, calls := failing(5, nats.ErrTimeout)
+
+		_, err := r.Filter()(h).Apply(context.Background(), "api", &Message{Method: http.MethodDelete})
+		assert.Equal(t, nats.ErrTimeout, err)
+		assert.Equal(t, 2, *calls)
+		assert.Equal(t, RetryStats{Requests: 1, Retries: 1, Exhausted: 1}, r.Stats())
+	})
+
+	t.Run("does not retry unsafe methods", func(t *testing.T) {
+		h, calls := failing(1, nats.ErrTimeout)
+
+		_, err := Retry()(h).Apply(context.Background(), "api", &Message{Method: http.MethodPost})
+		assert.Equal(t, nats.ErrTimeout, err)
+		assert.Equal(t, 1, *calls)
+	})
+
+	t.Run("retries requests with an idempotency key", func(t *testing.T) {
+		h, calls := failing(1, nats.ErrTimeout)
+		message := &Message{
+			Method: http.MethodPost,
+			Header: map[string]string{IdempotencyKeyHeader: "abc"},
+		}
+
+		_, err := Retry(RetryBackoff(time.Millisecond, time.Millisecond))(h).Apply(context.Background(), "api", message)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, *calls)
+	})
+
+	t.Run("splits the deadline across attempts", func(t *testing.T) {
+		var timeouts []time.Duration
+		h := func(ctx context.Context, subject string, message *Message) (*Message, error) {
+			deadline, _ := ctx.Deadline()
+			timeouts = append(timeouts, time.Until(deadline))
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*300)
+		defer cancel()
+
+		_, err := Retry(RetryBackoff(time.Millisecond, time.Millisecond))(h).Apply(ctx, "api", &Message{Method: http.MethodGet})
+		assert.NotNil(t, err)
+		if assert.Len(t, timeouts, 3) {
+			assert.InDelta(t, time.Millisecond*100, timeouts[0], float64(time.Millisecond*20))
+		}
+	})
+}
@@ -0,0 +1,267 @@
+package nats_proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// RewriteAction is the change a HeaderRewrite makes
+type RewriteAction string
+
+const (
+	// RewriteAdd sets the header only if it is not already present
+	RewriteAdd RewriteAction = "add"
+
+	// RewriteSet sets the header, replacing any existing value
+	RewriteSet RewriteAction = "set"
+
+	// RewriteRemove removes the header
+	RewriteRemove RewriteAction = "remove"
+
+	// RewriteRename moves the value of the header to the header named by To
+	RewriteRename RewriteAction = "rename"
+)
+
+// HeaderRewrite adds, sets, removes or renames a header of requests, responses or, if Direction is empty, both.
+// Values may refer to the request with ${request_id}, ${client_ip}, ${method}, ${subject} and ${host}, to environment
+// variables with ${env.NAME} and to route parameters with ${param.NAME}.  Route parameters are captured by Subjects
+// patterns such as api.users.{id}, where {id} matches a single token like *.  A rewrite with Subjects only applies to
+// matching subjects
+type HeaderRewrite struct {
+	Action    RewriteAction `json:"action"`
+	Header    string        `json:"header"`
+	Value     string        `json:"value,omitempty"`
+	To        string        `json:"to,omitempty"`
+	Direction Direction     `json:"direction,omitempty"`
+	Subjects  []string      `json:"subjects,omitempty"`
+}
+
+// matchParams returns true if the subject matches the pattern, capturing the tokens matched by {name}
+func matchParams(pattern, subject string) (map[string]string, bool) {
+	patterns := strings.Split(pattern, ".")
+	tokens := strings.Split(subject, ".")
+
+	var params map[string]string
+	for i, p := range patterns {
+		if p == ">" {
+			return params, len(tokens) > i
+		}
+		if i >= len(tokens) {
+			return nil, false
+		}
+		if strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}") {
+			if params == nil {
+				params = map[string]string{}
+			}
+			params[p[1:len(p)-1]] = tokens[i]
+			continue
+		}
+		if p != "*" && p != tokens[i] {
+			return nil, false
+		}
+	}
+	return params, len(patterns) == len(tokens)
+}
+
+// match returns true if the rewrite applies to the subject, along with any route parameters captured
+func (r HeaderRewrite) match(direction Direction, subject string) (map[string]string, bool) {
+	if r.Direction != "" && r.Direction != direction {
+		return nil, false
+	}
+	if len(r.Subjects) == 0 {
+		return nil, true
+	}
+	for _, pattern := range r.Subjects {
+		if params, ok := matchParams(pattern, subject); ok {
+			return params, true
+		}
+	}
+	return nil, false
+}
+
+// rewriteContext resolves template values for a single request
+type rewriteContext struct {
+	ctx       context.Context
+	subject   string
+	message   *Message
+	requestID string
+}
+
+func (c *rewriteContext) value(name string, params map[string]string) string {
+	switch {
+	case name == "request_id":
+		if c.requestID == "" {
+			c.requestID = requestID(c.ctx, c.message)
+		}
+		return c.requestID
+	case name == "client_ip":
+		addr := c.message.GetClient().GetRemoteAddr()
+		if req, ok := HTTPRequest(c.ctx); ok && addr == "" {
+			addr = req.RemoteAddr
+		}
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			return host
+		}
+		return addr
+	case name == "method":
+		return c.message.GetMethod()
+	case name == "subject":
+		return c.subject
+	case name == "host":
+		if host := c.message.GetClient().GetHost(); host != "" {
+			return host
+		}
+		if req, ok := HTTPRequest(c.ctx); ok {
+			return req.Host
+		}
+		return ""
+	case strings.HasPrefix(name, "env."):
+		return os.Getenv(name[len("env."):])
+	case strings.HasPrefix(name, "param."):
+		return params[name[len("param."):]]
+	default:
+		return ""
+	}
+}
+
+// expand replaces each ${name} in the template with its value
+func (c *rewriteContext) expand(template string, params map[string]string) string {
+	if !strings.Contains(template, "${") {
+		return template
+	}
+
+	buf := &strings.Builder{}
+	for {
+		start := strings.Index(template, "${")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(template[start:], "}")
+		if end < 0 {
+			break
+		}
+		buf.WriteString(template[:start])
+		buf.WriteString(c.value(template[start+2:start+end], params))
+		template = template[start+end+1:]
+	}
+	buf.WriteString(template)
+	return buf.String()
+}
+
+// requestID returns the id of the request from its X-Request-Id header or, if there is none, a new random id
+func requestID(ctx context.Context, message *Message) string {
+	if req, ok := HTTPRequest(ctx); ok {
+		if id := req.Header.Get(requestIDHeader); id != "" {
+			return id
+		}
+	}
+	if id := message.GetHeader()[requestIDHeader]; id != "" {
+		return id
+	}
+
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// apply performs the rewrites for the direction against the headers
+func (c *rewriteContext) apply(rewrites []HeaderRewrite, direction Direction, header map[string]string) {
+	for _, r := range rewrites {
+		params, ok := r.match(direction, c.subject)
+		if !ok {
+			continue
+		}
+
+		key := http.CanonicalHeaderKey(r.Header)
+		switch r.Action {
+		case RewriteAdd:
+			if _, exists := header[key]; !exists {
+				header[key] = c.expand(r.Value, params)
+			}
+		case RewriteSet:
+			header[key] = c.expand(r.Value, params)
+		case RewriteRemove:
+			delete(header, key)
+		case RewriteRename:
+			if value, exists := header[key]; exists {
+				delete(header, key)
+				header[http.CanonicalHeaderKey(r.To)] = value
+			}
+		}
+	}
+}
+
+// Rewrite returns a Filter that rewrites the headers of requests before they are sent to services and of replies
+// before they are returned to clients
+func Rewrite(rewrites ...HeaderRewrite) Filter {
+	return func(h Handler) Handler {
+		return func(ctx context.Context, subject string, message *Message) (*Message, error) {
+			if message == nil {
+				return h(ctx, subject, message)
+			}
+
+			c := &rewriteContext{ctx: ctx, subject: subject, message: message}
+			if message.Header == nil {
+				message.Header = map[string]string{}
+			}
+			c.apply(rewrites, DirectionRequest, message.Header)
+
+			out, err := h(ctx, subject, message)
+			if err != nil || out == nil {
+				return out, err
+			}
+
+			// replies may be shared e.g. by the cache
+			rewritten := *out
+			rewritten.Header = copyHeader(out.Header)
+			c.apply(rewrites, DirectionResponse, rewritten.Header)
+			return &rewritten, nil
+		}
+	}
+}
+
+// ParseRewrites parses a json array of HeaderRewrites
+func ParseRewrites(data []byte) ([]HeaderRewrite, error) {
+	var rewrites []HeaderRewrite
+	if err := json.Unmarshal(data, &rewrites); err != nil {
+		return nil, errors.Wrap(err, "invalid rewrites")
+	}
+
+	for i, r := range rewrites {
+		switch r.Action {
+		case RewriteAdd, RewriteSet, RewriteRemove:
+		case RewriteRename:
+			if r.To == "" {
+				return nil, errors.Errorf("invalid rewrites, rewrite %v: rename requires to", i)
+			}
+		default:
+			return nil, errors.Errorf("invalid rewrites, rewrite %v: action must be add, set, remove or rename", i)
+		}
+		if r.Header == "" {
+			return nil, errors.Errorf("invalid rewrites, rewrite %v: header required", i)
+		}
+		if r.Direction != "" && r.Direction != DirectionRequest && r.Direction != DirectionResponse {
+			return nil, errors.Errorf("invalid rewrites, rewrite %v: direction must be request or response", i)
+		}
+	}
+
+	return rewrites, nil
+}
+
+// ReadRewrites reads a json array of HeaderRewrites from a file
+func ReadRewrites(filename string) ([]HeaderRewrite, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRewrites(data)
+}
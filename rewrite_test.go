@@ -0,0 +1,111 @@
+package nats_proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchParams(t *testing.T) {
+	testCases := map[string]struct {
+		Pattern string
+		Subject string
+		Params  map[string]string
+		OK      bool
+	}{
+		"exact":    {Pattern: "api.users", Subject: "api.users", OK: true},
+		"param":    {Pattern: "api.users.{id}", Subject: "api.users.123", Params: map[string]string{"id": "123"}, OK: true},
+		"params":   {Pattern: "api.{kind}.{id}", Subject: "api.users.123", Params: map[string]string{"kind": "users", "id": "123"}, OK: true},
+		"tail":     {Pattern: "api.{kind}.>", Subject: "api.users.123.orders", Params: map[string]string{"kind": "users"}, OK: true},
+		"short":    {Pattern: "api.users.{id}", Subject: "api.users"},
+		"long":     {Pattern: "api.users.{id}", Subject: "api.users.123.orders"},
+		"mismatch": {Pattern: "api.orders.{id}", Subject: "api.users.123"},
+	}
+
+	for label, tc := range testCases {
+		t.Run(label, func(t *testing.T) {
+			params, ok := matchParams(tc.Pattern, tc.Subject)
+			assert.Equal(t, tc.OK, ok)
+			if ok {
+				assert.Equal(t, tc.Params, params)
+			}
+		})
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	os.Setenv("NATS_PROXY_TEST_REGION", "eu-west-1")
+	defer os.Unsetenv("NATS_PROXY_TEST_REGION")
+
+	rewrites, err := ParseRewrites([]byte(`[
+		{"action": "add", "header": "X-Request-Id", "value": "${request_id}"},
+		{"action": "set", "header": "X-User-Id", "value": "${param.id}", "subjects": ["api.users.{id}"], "direction": "request"},
+		{"action": "set", "header": "X-Client", "value": "${client_ip} ${method} ${subject}", "direction": "request"},
+		{"action": "rename", "header": "X-Legacy", "to": "X-Modern", "direction": "request"},
+		{"action": "set", "header": "X-Region", "value": "${env.NATS_PROXY_TEST_REGION}", "direction": "response"},
+		{"action": "remove", "header": "Server", "direction": "response"}
+	]`))
+	assert.Nil(t, err)
+
+	reply := &Message{Status: http.StatusOK, Header: map[string]string{"Server": "internal"}}
+	var received *Message
+	h := func(ctx context.Context, subject string, message *Message) (*Message, error) {
+		received = message
+		return reply, nil
+	}
+
+	gw, err := NewGateway(
+		WithHandler(h),
+		WithHeaders("X-Legacy"),
+		WithFilters(Rewrite(rewrites...)),
+	)
+	assert.Nil(t, err)
+	defer gw.Close()
+
+	t.Run("generated id", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://localhost/users/123", nil)
+		req.RemoteAddr = "203.0.113.1:1234"
+		req.Header.Set("X-Legacy", "abc")
+		w := httptest.NewRecorder()
+		gw.ServeHTTP(w, req)
+
+		id := received.Header["X-Request-Id"]
+		assert.Len(t, id, 32)
+		assert.Equal(t, id, w.Header().Get("X-Request-Id"))
+		assert.Equal(t, "123", received.Header["X-User-Id"])
+		assert.Equal(t, "203.0.113.1 GET api.users.123", received.Header["X-Client"])
+		assert.Equal(t, "abc", received.Header["X-Modern"])
+		assert.NotContains(t, received.Header, "X-Legacy")
+
+		assert.Equal(t, "eu-west-1", w.Header().Get("X-Region"))
+		assert.Equal(t, "", w.Header().Get("Server"))
+		assert.Equal(t, "internal", reply.Header["Server"])
+	})
+
+	t.Run("client id", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://localhost/orders", nil)
+		req.Header.Set("X-Request-Id", "abc123")
+		w := httptest.NewRecorder()
+		gw.ServeHTTP(w, req)
+
+		assert.Equal(t, "abc123", received.Header["X-Request-Id"])
+		assert.Equal(t, "abc123", w.Header().Get("X-Request-Id"))
+		assert.NotContains(t, received.Header, "X-User-Id")
+	})
+
+	for label, data := range map[string]string{
+		"action":    `[{"action": "copy", "header": "X"}]`,
+		"header":    `[{"action": "set", "value": "x"}]`,
+		"rename":    `[{"action": "rename", "header": "X"}]`,
+		"direction": `[{"action": "remove", "header": "X", "direction": "both"}]`,
+	} {
+		t.Run(label, func(t *testing.T) {
+			_, err := ParseRewrites([]byte(data))
+			assert.NotNil(t, err)
+		})
+	}
+}
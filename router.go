@@ -10,19 +10,30 @@ import (
 	"net/http/httptest"
 	"os"
 	"strings"
+	"sync/atomic"
+	"time"
 
-	"github.com/gogo/protobuf/proto"
-	"github.com/nats-io/go-nats"
+	"github.com/nats-io/nats.go"
 )
 
 // Router provides a wrapper over the standard http.Handler interface and acts as a bridge between the http.Handler and
 // nats
 type Router struct {
+	inFlight       int64 // number of messages currently being handled; accessed atomically
 	h              http.Handler
 	nc             *nats.Conn
-	subject        string // root subject to publish to
-	queue          string // name of queue for QueueSubscribe
-	returnNotFound bool   // should router reply to 404 responses
+	subject        string         // root subject to publish to
+	queue          string         // name of queue for QueueSubscribe
+	returnNotFound bool           // should router reply to 404 responses
+	drainTimeout   time.Duration  // max time to wait for in-flight messages on shutdown; 0 unsubscribes immediately
+	id             string         // unique id of this router instance
+	version        string         // version of the service, if known
+	routes         []string       // routes served by the handler, if known
+	heartbeat      time.Duration  // interval between announcements; 0 disables announcements
+	controlSubject string         // subject announcements are published to
+	verifyKeys     map[string]Key // keys signatures are accepted from; nil accepts unsigned messages
+	nonces         *nonceCache    // nonces of verified messages, to reject replays; only set with verifyKeys
+	compression    compression    // encodings replies may be compressed with
 }
 
 // Wrap an existing http.Handler with the specified options
@@ -38,12 +49,25 @@ func Wrap(h http.Handler, opts ...Option) (*Router, error) {
 		subject:        c.subject,
 		queue:          c.queue,
 		returnNotFound: c.returnNotFound,
+		drainTimeout:   c.drainTimeout,
+		id:             newInstanceID(),
+		version:        c.version,
+		routes:         c.routes,
+		heartbeat:      c.heartbeat,
+		controlSubject: c.controlSubject,
+		verifyKeys:     c.verifyKeys,
+		compression:    c.compression,
+	}
+	if r.verifyKeys != nil {
+		r.nonces = newNonceCache()
 	}
 
 	return r, nil
 }
 
-// Subscribe listens to the subject specified
+// Subscribe listens to the subject specified.  When ctx is done, the router stops accepting new messages, waits (up to
+// the drain timeout) for messages already received to be handled and their replies published, and then closes the
+// returned channel
 func (r *Router) Subscribe(ctx context.Context) (<-chan struct{}, error) {
 	subject := r.subject
 	for strings.HasSuffix(subject, ".") {
@@ -61,37 +85,127 @@ func (r *Router) Subscribe(ctx context.Context) (<-chan struct{}, error) {
 		return nil, err
 	}
 
+	beating := make(chan struct{})
+	go func() {
+		defer close(beating)
+		r.heartbeats(ctx)
+	}()
+
 	done := make(chan struct{})
 
 	go func() {
 		defer close(done)
-		defer root.Unsubscribe()
-		defer children.Unsubscribe()
 		<-ctx.Done()
+		<-beating
+		r.drain(root, children)
 	}()
 
 	return done, nil
 }
 
+// drain stops the subscriptions from receiving new messages and waits for pending and in-flight messages to complete,
+// giving up once drainTimeout has elapsed
+func (r *Router) drain(subs ...*nats.Subscription) {
+	if r.drainTimeout <= 0 {
+		for _, sub := range subs {
+			sub.Unsubscribe()
+		}
+		return
+	}
+
+	deadline := time.Now().Add(r.drainTimeout)
+
+	for _, sub := range subs {
+		if err := sub.Drain(); err != nil {
+			log.Printf("Unable to drain subscription, %v, %v\n", sub.Subject, err)
+		}
+	}
+
+	for time.Now().Before(deadline) && (r.isValid(subs...) || atomic.LoadInt64(&r.inFlight) > 0) {
+		time.Sleep(drainInterval)
+	}
+
+	for _, sub := range subs {
+		if sub.IsValid() {
+			sub.Unsubscribe()
+		}
+	}
+
+	if remaining := time.Until(deadline); remaining > 0 {
+		if err := r.nc.FlushTimeout(remaining); err != nil {
+			log.Printf("Unable to flush replies, %v\n", err)
+		}
+	}
+}
+
+func (r *Router) isValid(subs ...*nats.Subscription) bool {
+	for _, sub := range subs {
+		if sub.IsValid() {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *Router) handler(msg *nats.Msg) {
-	m := &Message{}
-	if err := proto.Unmarshal(msg.Data, m); err != nil {
+	atomic.AddInt64(&r.inFlight, 1)
+	defer atomic.AddInt64(&r.inFlight, -1)
+
+	m, codec, err := decodeMessage(msg)
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "ERR: unable to unmarshal *Message from *nats.Msg, %v\n", err)
 		return
 	}
 
+	if err := decompressBody(m); err != nil {
+		fmt.Fprintf(os.Stderr, "ERR: unable to decompress *Message, %v\n", err)
+		if msg.Reply != "" {
+			w := httptest.NewRecorder()
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			r.reply(msg.Reply, codec, w, nil)
+		}
+		return
+	}
+
+	if r.verifyKeys != nil {
+		if err := r.verify(msg.Subject, m); err != nil {
+			fmt.Fprintf(os.Stderr, "ERR: rejected message on %v, %v\n", msg.Subject, err)
+			if msg.Reply != "" {
+				w := httptest.NewRecorder()
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				r.reply(msg.Reply, codec, w, nil)
+			}
+			return
+		}
+	}
+
 	req, err := requestFromMessage(m, r.subject, msg.Subject)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "ERR: unable to create *Request from *Message, %v\n", err)
 		return
 	}
+	if r.verifyKeys != nil && m.Identity != nil {
+		req = withIdentity(req, m.Identity) // anyone may publish an unsigned identity, so only trust a verified one
+	}
 
 	w := httptest.NewRecorder()
 	r.h.ServeHTTP(w, req)
 
 	if r.returnNotFound && msg.Reply != "" {
-		writeResponse(r.nc, msg.Reply, w)
+		r.reply(msg.Reply, codec, w, m.AcceptEncoding)
+	}
+}
+
+// verify checks the signature of the message and that it has not been received before
+func (r *Router) verify(subject string, m *Message) error {
+	now := time.Now()
+	if err := verifyMessage(r.verifyKeys, subject, m, now); err != nil {
+		return err
 	}
+	if !r.nonces.add(m.Signature, now) {
+		return ErrReplayed
+	}
+	return nil
 }
 
 func requestFromMessage(m *Message, rootSubject, subject string) (*http.Request, error) {
@@ -117,38 +231,61 @@ func requestFromMessage(m *Message, rootSubject, subject string) (*http.Request,
 		req.Header.Set(k, v)
 	}
 
-	for name, cookie := range m.Cookies {
+	for key, cookie := range m.Cookies {
 		req.AddCookie(&http.Cookie{
-			Name:  name,
+			Name:  cookieName(key, cookie),
 			Value: cookie.Value,
 			Path:  cookie.Path,
 		})
 	}
 
+	if m.Client != nil {
+		applyClient(req, m.Client)
+	}
+
 	return req, nil
 }
 
-func writeResponse(nc *nats.Conn, subject string, w *httptest.ResponseRecorder) {
+// withIdentity makes the identity available to the handler via RequestIdentity
+func withIdentity(req *http.Request, identity *Identity) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), identityKey{}, identity))
+}
+
+// reply publishes the recorded response with the Codec of the request, compressing the body with an encoding the
+// requester accepts
+func (r *Router) reply(subject string, codec Codec, w *httptest.ResponseRecorder, accept []string) {
 	m := &Message{
 		Status: int32(w.Code),
 		Header: map[string]string{},
 	}
 
 	for key := range w.HeaderMap {
+		if key == "Set-Cookie" {
+			continue
+		}
 		m.Header[key] = w.HeaderMap.Get(key)
 	}
+	m.Cookies = readSetCookies(w.HeaderMap["Set-Cookie"])
 
 	if w.Body != nil {
 		m.Body = w.Body.Bytes()
 	}
 
-	data, err := proto.Marshal(m)
-	if err != nil {
+	// bodies the service has already encoded e.g. Content-Encoding: gzip are passed through untouched
+	if len(accept) > 0 && m.Header["Content-Encoding"] == "" {
+		if err := compressBody(m, r.compression.encodings, accept, r.compression.threshold); err != nil {
+			log.Printf("Unable to compress message, %v\n", err)
+		}
+	}
+
+	msg := &nats.Msg{Subject: subject}
+	if err := codec.Encode(m, msg); err != nil {
 		log.Printf("Unable to marshal message, %v\n", err)
+		return
 	}
+	dropContentType(r.nc, msg)
 
-	err = nc.Publish(subject, data)
-	if err != nil {
+	if err := r.nc.PublishMsg(msg); err != nil {
 		log.Printf("Unable to publish message, %v\n", err)
 	}
 }
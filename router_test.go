@@ -1,13 +1,58 @@
 package nats_proxy
 
 import (
+	"context"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/gogo/protobuf/proto"
+	"github.com/nats-io/nats.go"
 	"github.com/stretchr/testify/assert"
 )
 
+// testRouter is a Router subscribed on a connection to the local nats server for the duration of a test
+type testRouter struct {
+	*Router
+	nc   *nats.Conn
+	stop func()
+}
+
+// newTestRouter wraps h with the options and subscribes it until stop is called or the test ends
+func newTestRouter(t *testing.T, h http.Handler, opts ...Option) *testRouter {
+	nc, err := nats.Connect(nats.DefaultURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+
+	r, err := Wrap(h, append([]Option{WithNats(nc)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done, err := r.Subscribe(ctx)
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			cancel()
+			<-done
+		})
+	}
+	t.Cleanup(stop)
+
+	return &testRouter{Router: r, nc: nc, stop: stop}
+}
+
 func TestRequestFromMessage(t *testing.T) {
 	hKey := "X-Key"
 	hValue := "value"
@@ -39,3 +84,58 @@ func TestRequestFromMessage(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, cookieValue, cookie.Value)
 }
+
+func TestSubscribeDrain(t *testing.T) {
+	started := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		time.Sleep(time.Millisecond * 250)
+		io.WriteString(w, "ok")
+	})
+
+	r := newTestRouter(t, h, WithSubject("drain"), WithDrainTimeout(time.Second*5))
+
+	data, err := proto.Marshal(&Message{Method: http.MethodGet})
+	assert.Nil(t, err)
+
+	replies := make(chan *nats.Msg, 1)
+	go func() {
+		msg, err := r.nc.Request("drain.foo", data, time.Second*5)
+		assert.Nil(t, err)
+		replies <- msg
+	}()
+
+	// When
+	<-started
+	r.stop()
+
+	// Then
+	select {
+	case msg := <-replies:
+		out := &Message{}
+		assert.Nil(t, proto.Unmarshal(msg.Data, out))
+		assert.Equal(t, "ok", string(out.Body))
+	case <-time.After(time.Millisecond * 100):
+		t.Fatal("expected in-flight request to be replied to before done was closed")
+	}
+}
+
+func TestUnsignedIdentity(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		identity, ok := RequestIdentity(req)
+		assert.False(t, ok)
+		io.WriteString(w, identity.GetSubject())
+	})
+	r := newTestRouter(t, h, WithSubject("unsigned"))
+
+	// without verify keys, the identity of a message may have been set by anyone
+	data, err := proto.Marshal(&Message{Method: "GET", Identity: &Identity{Subject: "mallory"}})
+	assert.Nil(t, err)
+	msg, err := r.nc.Request("unsigned.foo", data, time.Second*5)
+	assert.Nil(t, err)
+
+	out := &Message{}
+	assert.Nil(t, proto.Unmarshal(msg.Data, out))
+	assert.Equal(t, int32(http.StatusOK), out.Status)
+	assert.Equal(t, "", string(out.Body))
+}
@@ -0,0 +1,305 @@
+package nats_proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultSignatureTTL specifies how long a signed Message remains valid; it must also cover clock skew between the
+	// Gateway and Routers
+	DefaultSignatureTTL = time.Second * 30
+
+	algorithmHMAC    = "hmac-sha256"
+	algorithmEd25519 = "ed25519"
+)
+
+var (
+	// ErrUnsigned is returned when a Router that requires signatures receives a Message without one
+	ErrUnsigned = errors.New("nats_proxy: message not signed")
+
+	// ErrSignatureExpired is returned when the signature of a Message has expired
+	ErrSignatureExpired = errors.New("nats_proxy: signature expired")
+
+	// ErrUnknownKey is returned when a Message is signed with a key the Router does not know
+	ErrUnknownKey = errors.New("nats_proxy: unknown signing key")
+
+	// ErrInvalidSignature is returned when the signature of a Message does not match its contents
+	ErrInvalidSignature = errors.New("nats_proxy: invalid signature")
+
+	// ErrReplayed is returned when a Router receives a signed Message it has already seen
+	ErrReplayed = errors.New("nats_proxy: message replayed")
+)
+
+// Key signs Messages published by a Gateway and verifies them in a Router.  Keys are identified by ID so a Router may
+// accept several at once while keys are rotated
+type Key struct {
+	ID        string
+	algorithm string
+	secret    []byte
+	private   ed25519.PrivateKey
+	public    ed25519.PublicKey
+}
+
+// HMACKey returns a Key that signs and verifies with a shared secret
+func HMACKey(id string, secret []byte) Key {
+	return Key{ID: id, algorithm: algorithmHMAC, secret: secret}
+}
+
+// Ed25519Key returns a Key that signs with, and verifies against the public half of, the private key
+func Ed25519Key(id string, private ed25519.PrivateKey) Key {
+	return Key{ID: id, algorithm: algorithmEd25519, private: private, public: private.Public().(ed25519.PublicKey)}
+}
+
+// Ed25519PublicKey returns a Key that only verifies; Routers need not hold the private key
+func Ed25519PublicKey(id string, public ed25519.PublicKey) Key {
+	return Key{ID: id, algorithm: algorithmEd25519, public: public}
+}
+
+// ParseKey parses a key of the form hmac:ID:SECRET, ed25519:ID:SEED or ed25519-public:ID:PUBLIC where secrets and
+// keys are base64 encoded
+func ParseKey(s string) (Key, error) {
+	segments := strings.SplitN(s, ":", 3)
+	if len(segments) != 3 || segments[1] == "" {
+		return Key{}, errors.Errorf("invalid key; expected type:id:base64 e.g. hmac:2024-01:c2VjcmV0")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(segments[2])
+	if err != nil {
+		return Key{}, errors.Wrapf(err, "invalid key, %v", segments[1])
+	}
+
+	switch segments[0] {
+	case "hmac":
+		return HMACKey(segments[1], data), nil
+	case "ed25519":
+		if len(data) != ed25519.SeedSize {
+			return Key{}, errors.Errorf("invalid key, %v; expected %v byte seed", segments[1], ed25519.SeedSize)
+		}
+		return Ed25519Key(segments[1], ed25519.NewKeyFromSeed(data)), nil
+	case "ed25519-public":
+		if len(data) != ed25519.PublicKeySize {
+			return Key{}, errors.Errorf("invalid key, %v; expected %v byte public key", segments[1], ed25519.PublicKeySize)
+		}
+		return Ed25519PublicKey(segments[1], ed25519.PublicKey(data)), nil
+	default:
+		return Key{}, errors.Errorf("invalid key type, %v", segments[0])
+	}
+}
+
+// signedContent returns a canonical encoding of the portion of the message covered by its signature: the subject,
+// method, headers, cookies, identity, client, a SHA-256 digest of the uncompressed body, the expiry and the nonce
+func signedContent(subject string, m *Message, expires int64, nonce string) []byte {
+	buf := &bytes.Buffer{}
+	length := make([]byte, binary.MaxVarintLen64)
+	writeLen := func(n int) {
+		buf.Write(length[:binary.PutUvarint(length, uint64(n))])
+	}
+	write := func(s string) {
+		writeLen(len(s))
+		buf.WriteString(s)
+	}
+	writeMap := func(values map[string]string) {
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		writeLen(len(keys))
+		for _, k := range keys {
+			write(k)
+			write(values[k])
+		}
+	}
+
+	digest := sha256.Sum256(m.Body)
+
+	write("nats-proxy/2")
+	write(subject)
+	write(m.Method)
+	write(strconv.FormatInt(expires, 10))
+	write(nonce)
+	write(string(digest[:]))
+	writeMap(m.Header)
+
+	names := make([]string, 0, len(m.Cookies))
+	for name := range m.Cookies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	writeLen(len(names))
+	for _, name := range names {
+		write(name)
+		write(m.Cookies[name].GetValue())
+		write(m.Cookies[name].GetPath())
+	}
+
+	if m.Identity == nil {
+		write("")
+	} else {
+		write("identity")
+		write(m.Identity.Subject)
+		write(m.Identity.Method)
+		writeMap(m.Identity.Claims)
+	}
+
+	if m.Client == nil {
+		write("")
+	} else {
+		write("client")
+		write(m.Client.RemoteAddr)
+		write(m.Client.Host)
+		write(m.Client.Scheme)
+		write(m.Client.Proto)
+		if tls := m.Client.Tls; tls == nil {
+			write("")
+		} else {
+			certificate := sha256.Sum256(tls.PeerCertificate)
+			write("tls")
+			write(strconv.FormatUint(uint64(tls.Version), 10))
+			write(strconv.FormatUint(uint64(tls.CipherSuite), 10))
+			write(tls.ServerName)
+			write(tls.NegotiatedProtocol)
+			write(tls.PeerSubject)
+			write(string(certificate[:]))
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func (k Key) sign(content []byte) ([]byte, error) {
+	switch {
+	case k.algorithm == algorithmHMAC:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(content)
+		return mac.Sum(nil), nil
+	case k.algorithm == algorithmEd25519 && k.private != nil:
+		return ed25519.Sign(k.private, content), nil
+	default:
+		return nil, errors.Errorf("key, %v, is not able to sign", k.ID)
+	}
+}
+
+func (k Key) verify(content, signature []byte) bool {
+	switch k.algorithm {
+	case algorithmHMAC:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(content)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case algorithmEd25519:
+		return len(k.public) == ed25519.PublicKeySize && ed25519.Verify(k.public, content, signature)
+	default:
+		return false
+	}
+}
+
+// newNonce returns a random value identifying a single signed message
+func newNonce() string {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	return base64.RawURLEncoding.EncodeToString(nonce)
+}
+
+// signMessages signs each message with the key before it is published, and before its body is compressed.  The
+// message is copied so concurrent attempts, such as hedged requests, each carry their own signature and nonce
+func signMessages(h Handler, key Key, ttl time.Duration) Handler {
+	return func(ctx context.Context, subject string, message *Message) (*Message, error) {
+		if message == nil {
+			return h(ctx, subject, message)
+		}
+
+		expires := time.Now().Add(ttl).Unix()
+		nonce := newNonce()
+		value, err := key.sign(signedContent(subject, message, expires, nonce))
+		if err != nil {
+			return nil, err
+		}
+
+		signed := *message
+		signed.Signature = &Signature{
+			KeyId:     key.ID,
+			Algorithm: key.algorithm,
+			Expires:   expires,
+			Value:     value,
+			Nonce:     nonce,
+		}
+		return h(ctx, subject, &signed)
+	}
+}
+
+// verifyMessage verifies the signature of a message received on subject against the known keys.  The body must
+// already be decompressed
+func verifyMessage(keys map[string]Key, subject string, m *Message, now time.Time) error {
+	signature := m.Signature
+	if signature == nil || len(signature.Value) == 0 {
+		return ErrUnsigned
+	}
+	if signature.Nonce == "" {
+		return ErrInvalidSignature
+	}
+
+	key, ok := keys[signature.KeyId]
+	if !ok || key.algorithm != signature.Algorithm {
+		return ErrUnknownKey
+	}
+
+	if !key.verify(signedContent(subject, m, signature.Expires, signature.Nonce), signature.Value) {
+		return ErrInvalidSignature
+	}
+
+	if now.Unix() > signature.Expires {
+		return ErrSignatureExpired
+	}
+
+	return nil
+}
+
+// nonceCache remembers the nonces of verified messages until their signatures expire so replays can be rejected
+type nonceCache struct {
+	mu    sync.Mutex
+	seen  map[string]int64 // expiry of each nonce seen, keyed by key id and nonce
+	sweep int64            // time after which expired nonces are next removed
+}
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{seen: map[string]int64{}}
+}
+
+// add records the nonce of the signature, returning false if it has already been seen
+func (c *nonceCache) add(signature *Signature, now time.Time) bool {
+	key := signature.KeyId + "\n" + signature.Nonce
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Unix() >= c.sweep {
+		for k, expires := range c.seen {
+			if now.Unix() > expires {
+				delete(c.seen, k)
+			}
+		}
+		c.sweep = now.Unix() + 1
+	}
+
+	if _, ok := c.seen[key]; ok {
+		return false
+	}
+	c.seen[key] = signature.Expires
+	return true
+}
@@ -0,0 +1,173 @@
+package nats_proxy
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyMessage(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	oldKey := HMACKey("old", []byte("secret"))
+	newKey := Ed25519Key("new", private)
+	keys := map[string]Key{
+		oldKey.ID: oldKey,
+		newKey.ID: Ed25519PublicKey("new", private.Public().(ed25519.PublicKey)),
+	}
+
+	signed := func(key Key, ttl time.Duration) *Message {
+		var out *Message
+		h := signMessages(func(ctx context.Context, subject string, message *Message) (*Message, error) {
+			out = message
+			return nil, nil
+		}, key, ttl)
+
+		h(context.Background(), "api.foo", &Message{
+			Method:   "GET",
+			Header:   map[string]string{"X-User-Id": "a"},
+			Cookies:  map[string]*Cookie{"session": {Value: "s"}},
+			Identity: &Identity{Subject: "a", Method: "jwt", Claims: map[string]string{"roles": "admin"}},
+			Client: &Client{
+				RemoteAddr: "203.0.113.1:1234",
+				Scheme:     "https",
+				Tls:        &ClientTLS{Version: 0x0304, ServerName: "api.example.com", PeerSubject: "CN=a", PeerCertificate: []byte("certificate")},
+			},
+			Body: []byte(`{"amount":10}`),
+		})
+		return out
+	}
+
+	now := time.Now()
+	assert.Nil(t, verifyMessage(keys, "api.foo", signed(oldKey, time.Minute), now))
+	assert.Nil(t, verifyMessage(keys, "api.foo", signed(newKey, time.Minute), now))
+
+	assert.Equal(t, ErrUnsigned, verifyMessage(keys, "api.foo", &Message{Method: "GET"}, now))
+	assert.Equal(t, ErrUnknownKey, verifyMessage(keys, "api.foo", signed(HMACKey("other", []byte("secret")), time.Minute), now))
+	assert.Equal(t, ErrSignatureExpired, verifyMessage(keys, "api.foo", signed(oldKey, time.Minute), now.Add(time.Minute*2)))
+	assert.Equal(t, ErrInvalidSignature, verifyMessage(keys, "api.bar", signed(oldKey, time.Minute), now))
+
+	m := signed(newKey, time.Minute)
+	m.Header["X-User-Id"] = "b"
+	assert.Equal(t, ErrInvalidSignature, verifyMessage(keys, "api.foo", m, now))
+
+	m = signed(newKey, time.Minute)
+	m.Identity.Claims["roles"] = "root"
+	assert.Equal(t, ErrInvalidSignature, verifyMessage(keys, "api.foo", m, now))
+
+	m = signed(oldKey, time.Minute)
+	m.Signature.Expires += 3600
+	assert.Equal(t, ErrInvalidSignature, verifyMessage(keys, "api.foo", m, now))
+
+	m = signed(oldKey, time.Minute)
+	m.Client.Tls.PeerCertificate = []byte("other certificate")
+	assert.Equal(t, ErrInvalidSignature, verifyMessage(keys, "api.foo", m, now))
+
+	m = signed(oldKey, time.Minute)
+	m.Client.Tls.ServerName = "other.example.com"
+	assert.Equal(t, ErrInvalidSignature, verifyMessage(keys, "api.foo", m, now))
+
+	m = signed(oldKey, time.Minute)
+	m.Body = []byte(`{"amount":1000}`)
+	assert.Equal(t, ErrInvalidSignature, verifyMessage(keys, "api.foo", m, now))
+
+	m = signed(oldKey, time.Minute)
+	m.Signature.Nonce = newNonce()
+	assert.Equal(t, ErrInvalidSignature, verifyMessage(keys, "api.foo", m, now))
+
+	m = signed(oldKey, time.Minute)
+	m.Signature.Nonce = ""
+	assert.Equal(t, ErrInvalidSignature, verifyMessage(keys, "api.foo", m, now))
+}
+
+func TestNonceCache(t *testing.T) {
+	now := time.Now()
+	c := newNonceCache()
+
+	signature := &Signature{KeyId: "a", Nonce: "n1", Expires: now.Add(time.Minute).Unix()}
+	assert.True(t, c.add(signature, now))
+	assert.False(t, c.add(signature, now))
+	assert.True(t, c.add(&Signature{KeyId: "b", Nonce: "n1", Expires: signature.Expires}, now))
+
+	// expired nonces are forgotten; their signatures are rejected as expired instead
+	later := now.Add(time.Minute * 2)
+	assert.True(t, c.add(&Signature{KeyId: "a", Nonce: "n2", Expires: later.Add(time.Minute).Unix()}, later))
+	assert.NotContains(t, c.seen, "a\nn1")
+}
+
+func TestParseKey(t *testing.T) {
+	key, err := ParseKey("hmac:2024-01:" + base64.StdEncoding.EncodeToString([]byte("secret")))
+	assert.Nil(t, err)
+	assert.Equal(t, "2024-01", key.ID)
+
+	seed := make([]byte, ed25519.SeedSize)
+	key, err = ParseKey("ed25519:a:" + base64.StdEncoding.EncodeToString(seed))
+	assert.Nil(t, err)
+	assert.Equal(t, "a", key.ID)
+
+	_, err = ParseKey("ed25519:a:" + base64.StdEncoding.EncodeToString([]byte("short")))
+	assert.NotNil(t, err)
+
+	_, err = ParseKey("rsa:a:" + base64.StdEncoding.EncodeToString(seed))
+	assert.NotNil(t, err)
+}
+
+func TestSignedRouter(t *testing.T) {
+	key := HMACKey("a", []byte("secret"))
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		identity, _ := RequestIdentity(req)
+		io.WriteString(w, identity.GetSubject())
+	})
+	nc := newTestRouter(t, h, WithSubject("signed"), WithVerifyKeys(key)).nc
+
+	setIdentity := func(h Handler) Handler {
+		return func(ctx context.Context, subject string, message *Message) (*Message, error) {
+			message.Identity = &Identity{Subject: "alice"}
+			return h(ctx, subject, message)
+		}
+	}
+	gw, err := NewGateway(WithNats(nc), WithSubject("signed"), WithSigningKey(key), WithFilters(setIdentity))
+	assert.Nil(t, err)
+	defer gw.Close()
+
+	// capture the signed message as anyone on the bus could
+	captured := make(chan *nats.Msg, 1)
+	sub, err := nc.ChanSubscribe("signed.foo", captured)
+	assert.Nil(t, err)
+	defer sub.Unsubscribe()
+
+	w := httptest.NewRecorder()
+	gw.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/foo", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "alice", w.Body.String())
+
+	// replayed within the ttl
+	replay := <-captured
+	msg, err := nc.RequestMsg(&nats.Msg{Subject: replay.Subject, Header: replay.Header, Data: replay.Data}, time.Second*5)
+	assert.Nil(t, err)
+	replayed := &Message{}
+	assert.Nil(t, proto.Unmarshal(msg.Data, replayed))
+	assert.Equal(t, int32(http.StatusUnauthorized), replayed.Status)
+
+	// published directly, bypassing the gateway
+	data, err := proto.Marshal(&Message{Method: "GET", Identity: &Identity{Subject: "mallory"}})
+	assert.Nil(t, err)
+	msg, err = nc.Request("signed.foo", data, time.Second*5)
+	assert.Nil(t, err)
+
+	out := &Message{}
+	assert.Nil(t, proto.Unmarshal(msg.Data, out))
+	assert.Equal(t, int32(http.StatusUnauthorized), out.Status)
+}